@@ -0,0 +1,401 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// admin is a web console for managing links at /admin, for people
+// rather than programs. It logs in with an api token, which sets a
+// session cookie carrying the token's scopes: the read scope allows
+// links to be searched and viewed and the link-write scope allows them
+// to be created, changed, disabled and deleted. It uses plain html
+// forms, without javascript, each protected from cross site requests
+// by a csrf value.
+
+// adminPrefix is the path of the admin console
+const adminPrefix = "/admin"
+
+// adminRow is a link shown in the admin console
+type adminRow struct {
+	Link
+	Disabled bool
+	Clicks   uint64
+	Check    *healthCheck
+}
+
+// linkForm holds the fields of the admin link form
+type linkForm struct {
+	Short, Target, Status, Meta string
+	Disabled                    bool
+}
+
+// adminPage is the data common to admin pages
+type adminPage struct {
+	Title, User, CSRF string
+	CanWrite          bool
+}
+
+// newAdminPage makes the data for an admin page
+func newAdminPage(title string, sess adminSession) adminPage {
+	return adminPage{
+		Title:    title,
+		User:     sess.token.Name,
+		CSRF:     sess.csrf,
+		CanWrite: sess.token.allows(scopeLinkWrite),
+	}
+}
+
+// linkFormOf makes a form from a link. Metadata is written as
+// name=value lines, other than disabled, which is a checkbox.
+func linkFormOf(l Link) linkForm {
+	f := linkForm{Short: l.Short, Target: l.Target, Disabled: linkDisabled(l)}
+	if l.Status != 0 {
+		f.Status = fmt.Sprint(l.Status)
+	}
+	names := make([]string, 0, len(l.Meta))
+	for k := range l.Meta {
+		if k != metaDisabled {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		f.Meta += k + "=" + l.Meta[k] + "\n"
+	}
+	return f
+}
+
+// formLink reads the link form from a request, returning the form to
+// show again if the link is not valid
+func formLink(r *http.Request) (Link, linkForm, error) {
+	f := linkForm{
+		Short:    strings.TrimSpace(r.PostFormValue("short")),
+		Target:   strings.TrimSpace(r.PostFormValue("target")),
+		Status:   strings.TrimSpace(r.PostFormValue("status")),
+		Meta:     r.PostFormValue("meta"),
+		Disabled: r.PostFormValue("disabled") != "",
+	}
+	l := Link{Short: f.Short, Target: f.Target}
+	var err error
+	l.Status, err = parseStatus(f.Status)
+	if err != nil {
+		return l, f, err
+	}
+	for i, line := range strings.Split(f.Meta, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return l, f, fmt.Errorf("metadata line %d is not name=value", i+1)
+		}
+		name, value = strings.TrimSpace(name), strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if l.Meta == nil {
+			l.Meta = map[string]string{}
+		}
+		l.Meta[name] = value
+	}
+	if f.Disabled {
+		if l.Meta == nil {
+			l.Meta = map[string]string{}
+		}
+		l.Meta[metaDisabled] = "true"
+	} else {
+		delete(l.Meta, metaDisabled)
+	}
+	return l, f, nil
+}
+
+// matchLink reports if a link's short url, target or metadata contains
+// q, ignoring case
+func matchLink(l Link, q string) bool {
+	q = strings.ToLower(q)
+	if strings.Contains(strings.ToLower(l.Short), q) || strings.Contains(strings.ToLower(l.Target), q) {
+		return true
+	}
+	for _, v := range l.Meta {
+		if strings.Contains(strings.ToLower(v), q) {
+			return true
+		}
+	}
+	return false
+}
+
+// adminHandler is an admin page handler with its session
+type adminHandler func(http.ResponseWriter, *http.Request, adminSession)
+
+// adminAuth requires an admin session allowing scope, redirecting to the
+// login page if there is none. Forms posted must carry the session's
+// csrf value.
+func (s *server) adminAuth(scope string, next adminHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("X-Frame-Options", "DENY")
+		var sess adminSession
+		c, err := r.Cookie(sessionCookie)
+		ok := err == nil
+		if ok {
+			sess, ok = s.sessions.get(c.Value, time.Now())
+		}
+		if !ok {
+			http.Redirect(w, r, adminPrefix+"/login", http.StatusSeeOther)
+			return
+		}
+		if !sess.token.allows(scope) {
+			log.Printf("admin denied for %s %s: token %s lacks %s scope", r.Method, r.URL.Path, sess.token.Name, scope)
+			http.Error(w, fmt.Sprintf("token %s lacks the %s scope", sess.token.Name, scope), http.StatusForbidden)
+			return
+		}
+		if r.Method == http.MethodPost && !sameSecret(r.PostFormValue("csrf"), sess.csrf) {
+			log.Printf("admin csrf check failed for %s %s by %s", r.Method, r.URL.Path, sess.token.Name)
+			http.Error(w, "invalid form, please reload the page and try again", http.StatusForbidden)
+			return
+		}
+		next(w, r, sess)
+	}
+}
+
+// adminIndex redirects to the list of links
+func (s *server) adminIndex(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, adminPrefix+"/links", http.StatusSeeOther)
+}
+
+// adminLogin shows the login form, setting a cookie with the form's csrf
+// value
+func (s *server) adminLogin(w http.ResponseWriter, r *http.Request) {
+	s.showLogin(w, r, http.StatusOK, "")
+}
+
+// showLogin shows the login form with an optional error
+func (s *server) showLogin(w http.ResponseWriter, r *http.Request, status int, message string) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	csrf, err := newToken()
+	if err != nil {
+		log.Printf("admin login error: %v", err)
+		http.Error(w, "could not start login", http.StatusInternalServerError)
+		return
+	}
+	setAdminCookie(w, r, loginCookie, csrf, 0)
+	vars := struct {
+		Title, CSRF, Error string
+	}{"Admin login", csrf, message}
+	w.WriteHeader(status)
+	if err := s.adminLoginTpl.Execute(w, vars); err != nil {
+		errorOutput(w, "admin login", err)
+	}
+}
+
+// adminLoginPost logs in with an api token, starting a session. Failed
+// attempts are counted with those to the api.
+func (s *server) adminLoginPost(w http.ResponseWriter, r *http.Request) {
+	client, now := clientAddr(r), time.Now()
+	c, err := r.Cookie(loginCookie)
+	if err != nil || !sameSecret(r.PostFormValue("csrf"), c.Value) {
+		log.Printf("admin login csrf check failed from %s", client)
+		s.showLogin(w, r, http.StatusForbidden, "The form has expired, please try again.")
+		return
+	}
+	if s.authFails.blocked(client, now) {
+		log.Printf("admin login refused: too many failed attempts from %s", client)
+		s.showLogin(w, r, http.StatusTooManyRequests, "Too many failed attempts, please try again later.")
+		return
+	}
+	t, err := s.tokens.authenticate(strings.TrimSpace(r.PostFormValue("token")))
+	if err != nil {
+		n := s.authFails.fail(client, now)
+		log.Printf("admin login failed from %s: invalid token (%d recent failures)", client, n)
+		s.showLogin(w, r, http.StatusUnauthorized, "The token is not valid.")
+		return
+	}
+	if !t.allows(scopeRead) {
+		log.Printf("admin login denied: token %s lacks %s scope", t.Name, scopeRead)
+		s.showLogin(w, r, http.StatusForbidden, "The token may not read links.")
+		return
+	}
+	id, _, err := s.sessions.create(t, now)
+	if err != nil {
+		log.Printf("admin login error: %v", err)
+		http.Error(w, "could not start session", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("admin login by token %s from %s", t.Name, client)
+	setAdminCookie(w, r, loginCookie, "", -1)
+	setAdminCookie(w, r, sessionCookie, id, int(s.sessions.ttl.Seconds()))
+	http.Redirect(w, r, adminPrefix+"/links", http.StatusSeeOther)
+}
+
+// adminLogout ends the session
+func (s *server) adminLogout(w http.ResponseWriter, r *http.Request, sess adminSession) {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		s.sessions.remove(c.Value)
+	}
+	log.Printf("admin logout by token %s", sess.token.Name)
+	setAdminCookie(w, r, sessionCookie, "", -1)
+	http.Redirect(w, r, adminPrefix+"/login", http.StatusSeeOther)
+}
+
+// adminRowOf makes an admin row from a link
+func (s *server) adminRowOf(l Link) adminRow {
+	row := adminRow{Link: l, Disabled: linkDisabled(l), Clicks: s.clicks.get(l.Short)}
+	if s.monitor != nil {
+		if check, ok := s.monitor.lastCheck(l.Short); ok {
+			row.Check = &check
+		}
+	}
+	return row
+}
+
+// adminLinks lists the links matching an optional query
+func (s *server) adminLinks(w http.ResponseWriter, r *http.Request, sess adminSession) {
+	links, err := s.store.List(r.Context())
+	if err != nil {
+		log.Printf("admin list error: %v", err)
+		http.Error(w, "could not list links", http.StatusInternalServerError)
+		return
+	}
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	vars := struct {
+		adminPage
+		Query, Done, Short string
+		Monitored          bool
+		Total              int
+		Rows               []adminRow
+	}{
+		adminPage: newAdminPage("Links", sess),
+		Query:     q,
+		Done:      r.URL.Query().Get("done"),
+		Short:     r.URL.Query().Get("short"),
+		Monitored: s.monitor != nil,
+		Total:     len(links),
+	}
+	for _, l := range links {
+		if q == "" || matchLink(l, q) {
+			vars.Rows = append(vars.Rows, s.adminRowOf(l))
+		}
+	}
+	if err := s.adminLinksTpl.Execute(w, vars); err != nil {
+		errorOutput(w, "admin links", err)
+	}
+}
+
+// showLinkForm shows the form to create a link, if row is nil, or to
+// edit a link, with an optional error
+func (s *server) showLinkForm(w http.ResponseWriter, sess adminSession, status int, f linkForm, row *adminRow, message string) {
+	title := "New link"
+	if row != nil {
+		title = "Link " + row.Short
+	}
+	vars := struct {
+		adminPage
+		Form  linkForm
+		Row   *adminRow
+		Error string
+	}{newAdminPage(title, sess), f, row, message}
+	w.WriteHeader(status)
+	if err := s.adminEditTpl.Execute(w, vars); err != nil {
+		errorOutput(w, "admin link", err)
+	}
+}
+
+// adminDone redirects to the list of links, reporting a change
+func adminDone(w http.ResponseWriter, r *http.Request, done, shortURL string) {
+	v := url.Values{"done": {done}, "short": {shortURL}}
+	http.Redirect(w, r, adminPrefix+"/links?"+v.Encode(), http.StatusSeeOther)
+}
+
+// adminNew shows the form to create a link
+func (s *server) adminNew(w http.ResponseWriter, r *http.Request, sess adminSession) {
+	s.showLinkForm(w, sess, http.StatusOK, linkForm{}, nil, "")
+}
+
+// adminCreate creates a link
+func (s *server) adminCreate(w http.ResponseWriter, r *http.Request, sess adminSession) {
+	l, f, err := formLink(r)
+	if err != nil {
+		s.showLinkForm(w, sess, http.StatusBadRequest, f, nil, err.Error())
+		return
+	}
+	l, err = s.createLink(r.Context(), l, "admin "+sess.token.Name)
+	switch {
+	case errors.Is(err, invalidLinkError):
+		s.showLinkForm(w, sess, http.StatusBadRequest, f, nil, err.Error())
+	case errors.Is(err, linkExistsError):
+		s.showLinkForm(w, sess, http.StatusConflict, f, nil, fmt.Sprintf("short url %s already exists", l.Short))
+	case err != nil:
+		log.Printf("admin create error for %s: %v", l.Short, err)
+		http.Error(w, "could not create link", http.StatusInternalServerError)
+	default:
+		adminDone(w, r, "created", l.Short)
+	}
+}
+
+// adminLink shows the form to edit a link
+func (s *server) adminLink(w http.ResponseWriter, r *http.Request, sess adminSession) {
+	shortURL := r.PathValue("shortURL")
+	l, err := s.store.Lookup(r.Context(), shortURL)
+	if err != nil {
+		s.adminLookupFail(w, shortURL, err)
+		return
+	}
+	row := s.adminRowOf(l)
+	s.showLinkForm(w, sess, http.StatusOK, linkFormOf(l), &row, "")
+}
+
+// adminUpdate changes a link
+func (s *server) adminUpdate(w http.ResponseWriter, r *http.Request, sess adminSession) {
+	shortURL := r.PathValue("shortURL")
+	existing, err := s.store.Lookup(r.Context(), shortURL)
+	if err != nil {
+		s.adminLookupFail(w, shortURL, err)
+		return
+	}
+	row := s.adminRowOf(existing)
+	l, f, err := formLink(r)
+	f.Short, l.Short = shortURL, shortURL
+	if err != nil {
+		s.showLinkForm(w, sess, http.StatusBadRequest, f, &row, err.Error())
+		return
+	}
+	_, err = s.updateLink(r.Context(), l, "admin "+sess.token.Name)
+	switch {
+	case errors.Is(err, invalidLinkError):
+		s.showLinkForm(w, sess, http.StatusBadRequest, f, &row, err.Error())
+	case err != nil:
+		s.adminLookupFail(w, shortURL, err)
+	default:
+		adminDone(w, r, "updated", shortURL)
+	}
+}
+
+// adminDelete deletes a link
+func (s *server) adminDelete(w http.ResponseWriter, r *http.Request, sess adminSession) {
+	shortURL := r.PathValue("shortURL")
+	err := s.deleteLink(r.Context(), shortURL, "admin "+sess.token.Name)
+	if err != nil {
+		s.adminLookupFail(w, shortURL, err)
+		return
+	}
+	adminDone(w, r, "deleted", shortURL)
+}
+
+// adminLookupFail reports a link that could not be found or changed
+func (s *server) adminLookupFail(w http.ResponseWriter, shortURL string, err error) {
+	if errors.Is(err, linkNotFoundError) {
+		http.Error(w, fmt.Sprintf("short url %s not found", shortURL), http.StatusNotFound)
+		return
+	}
+	log.Printf("admin error for %s: %v", shortURL, err)
+	http.Error(w, "could not change link", http.StatusInternalServerError)
+}
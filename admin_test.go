@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// adminClient is a browser session on the admin console, keeping cookies
+// and the last csrf value seen
+type adminClient struct {
+	t       *testing.T
+	handler http.Handler
+	cookies map[string]*http.Cookie
+	csrf    string
+}
+
+var csrfRegex = regexp.MustCompile(`name="csrf" value="([^"]+)"`)
+
+// do makes a request, posting form if it is not nil
+func (c *adminClient) do(method, path string, form url.Values) *httptest.ResponseRecorder {
+	c.t.Helper()
+	var r *http.Request
+	if form != nil {
+		r = httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		r = httptest.NewRequest(method, path, nil)
+	}
+	r.RemoteAddr = "10.0.0.1:1234"
+	for _, ck := range c.cookies {
+		r.AddCookie(ck)
+	}
+	rec := httptest.NewRecorder()
+	c.handler.ServeHTTP(rec, r)
+	for _, ck := range rec.Result().Cookies() {
+		if ck.MaxAge < 0 {
+			delete(c.cookies, ck.Name)
+			continue
+		}
+		c.cookies[ck.Name] = ck
+	}
+	if m := csrfRegex.FindStringSubmatch(rec.Body.String()); m != nil {
+		c.csrf = m[1]
+	}
+	return rec
+}
+
+// login logs in with token, returning the response
+func (c *adminClient) login(token string) *httptest.ResponseRecorder {
+	c.t.Helper()
+	c.do("GET", "/admin/login", nil)
+	return c.do("POST", "/admin/login", url.Values{"csrf": {c.csrf}, "token": {token}})
+}
+
+func TestAdmin(t *testing.T) {
+	path := tmpLinkFile(t, "def,https://def\nabc,https://abc,302\n")
+	tokens, secrets := testTokens(t, map[string]string{"editor": "link-write", "reader": "read"})
+	ns, err := newServer(false, "", "", 0, 0, withDataFile(path, time.Second), withAdmin(), withTokens(tokens))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &adminClient{t: t, handler: ns.authenticate(ns.mux()), cookies: map[string]*http.Cookie{}}
+
+	// not logged in
+	rec := c.do("GET", "/admin/links", nil)
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/admin/login" {
+		t.Fatalf("expected redirect to login, got %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	if rec := c.login("wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("bad token login got %d", rec.Code)
+	}
+	rec = c.do("POST", "/admin/login", url.Values{"csrf": {"forged"}, "token": {secrets["editor"]}})
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("forged login got %d", rec.Code)
+	}
+	if rec := c.login(secrets["editor"]); rec.Code != http.StatusSeeOther {
+		t.Fatalf("login got %d: %s", rec.Code, rec.Body)
+	}
+	ck := c.cookies[sessionCookie]
+	if ck == nil || !ck.HttpOnly || ck.SameSite != http.SameSiteLaxMode || ck.Path != "/admin" {
+		t.Fatalf("unexpected session cookie %+v", ck)
+	}
+	ns.clicks.add("abc")
+
+	form := func(short, target, status, meta string, disabled bool) url.Values {
+		v := url.Values{"short": {short}, "target": {target}, "status": {status}, "meta": {meta}}
+		if disabled {
+			v.Set("disabled", "true")
+		}
+		return v
+	}
+	tests := []struct {
+		method, path string
+		form         url.Values
+		csrf         bool
+		status       int
+		contains     string
+	}{
+		{"GET", "/admin", nil, false, 303, ""},
+		{"GET", "/admin/links", nil, false, 200, "2 of 2 links"},
+		{"GET", "/admin/links?q=ABC", nil, false, 200, "1 of 2 links"},
+		{"GET", "/admin/links/abc", nil, false, 200, "1 clicks"},
+		{"GET", "/admin/links/nope", nil, false, 404, "not found"},
+		{"GET", "/admin/new", nil, false, 200, "New link"},
+		{"POST", "/admin/new", form("ghi", "https://ghi", "", "owner=rory", false), true, 303, ""},
+		{"POST", "/admin/new", form("jkl", "https://jkl", "", "", false), false, 403, "invalid form"},
+		{"POST", "/admin/new", form("ghi", "https://other", "", "", false), true, 409, "already exists"},
+		{"POST", "/admin/new", form("", "https://generated", "", "", false), true, 303, ""},
+		{"GET", "/admin/links?q=generated", nil, false, 200, "1 of 4 links"},
+		{"POST", "/admin/new", form("a_b", "https://ab", "", "", false), true, 400, "invalid characters"},
+		{"POST", "/admin/new", form("jkl", "https://jkl", "200", "", false), true, 400, "redirect status"},
+		{"POST", "/admin/new", form("jkl", "https://jkl", "", "owner", false), true, 400, "not name=value"},
+		{"GET", "/admin/links?q=rory", nil, false, 200, "1 of 4 links"},
+		{"POST", "/admin/links/abc", form("", "https://abc/new", "307", "", true), true, 303, ""},
+		{"GET", "/abc", nil, false, 404, "not found"},
+		{"GET", "/admin/links/abc", nil, false, 200, `value="true" checked`},
+		{"POST", "/admin/links/abc", form("", "https://abc/new", "", "", false), true, 303, ""},
+		{"GET", "/abc", nil, false, 301, ""},
+		{"POST", "/admin/links/def/delete", url.Values{}, true, 303, ""},
+		{"POST", "/admin/links/def/delete", url.Values{}, true, 404, "not found"},
+		{"GET", "/admin/links", nil, false, 200, "3 of 3 links"},
+	}
+	for i, tt := range tests {
+		// in order, as each test changes the links
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			if tt.csrf {
+				tt.form.Set("csrf", c.csrf)
+			}
+			rec := c.do(tt.method, tt.path, tt.form)
+			if rec.Code != tt.status {
+				t.Errorf("%s %s status got %d want %d: %s", tt.method, tt.path, rec.Code, tt.status, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.contains) {
+				t.Errorf("%s %s body does not contain %s: %s", tt.method, tt.path, tt.contains, rec.Body)
+			}
+		})
+	}
+
+	// changes are saved
+	want := "short,target,status,owner\nabc,https://abc/new,,\nghi,https://ghi,,rory\n"
+	generated := regexp.MustCompile(`(?m)^[a-z0-9]{6},https://generated,,\n`)
+	got := readLinkFile(t, path)
+	if !generated.MatchString(got) {
+		t.Errorf("generated link not saved: %s", got)
+	}
+	got = generated.ReplaceAllString(got, "")
+	if got != want {
+		t.Errorf("file got\n%s\nwant\n%s", got, want)
+	}
+
+	// logging out ends the session
+	if rec := c.do("POST", "/admin/logout", url.Values{"csrf": {c.csrf}}); rec.Code != http.StatusSeeOther {
+		t.Errorf("logout got %d", rec.Code)
+	}
+	if rec := c.do("GET", "/admin/links", nil); rec.Code != http.StatusSeeOther {
+		t.Errorf("links after logout got %d", rec.Code)
+	}
+
+	// readers may not change links
+	if rec := c.login(secrets["reader"]); rec.Code != http.StatusSeeOther {
+		t.Fatalf("reader login got %d", rec.Code)
+	}
+	if rec := c.do("GET", "/admin/links/ghi", nil); rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "Save") {
+		t.Errorf("reader view got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := c.do("POST", "/admin/links/ghi/delete", url.Values{"csrf": {c.csrf}}); rec.Code != http.StatusForbidden {
+		t.Errorf("reader delete got %d", rec.Code)
+	}
+}
+
+func TestAdminDisabled(t *testing.T) {
+	ns, err := newServer(false, "", "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	ns.mux().ServeHTTP(rec, httptest.NewRequest("GET", "/admin/links", nil))
+	if got, want := rec.Code, http.StatusNotFound; got != want {
+		t.Errorf("status got %d want %d", got, want)
+	}
+
+	// the console is not served without tokens
+	_, err = newServer(false, "", "", 0, 0, withAdmin())
+	if err == nil {
+		t.Error("expected error for admin without tokens")
+	}
+}
+
+func TestFormLink(t *testing.T) {
+	tests := []struct {
+		form  url.Values
+		link  Link
+		isErr bool
+	}{
+		{
+			url.Values{"short": {" abc "}, "target": {"https://abc"}},
+			Link{Short: "abc", Target: "https://abc"},
+			false,
+		},
+		{
+			url.Values{"short": {"abc"}, "target": {"https://abc"}, "status": {"302"}, "meta": {"owner = rory\r\n\nnotes=\r\n"}, "disabled": {"true"}},
+			Link{Short: "abc", Target: "https://abc", Status: 302, Meta: map[string]string{"owner": "rory", metaDisabled: "true"}},
+			false,
+		},
+		{
+			url.Values{"short": {"abc"}, "target": {"https://abc"}, "meta": {"disabled=true"}},
+			Link{Short: "abc", Target: "https://abc", Meta: map[string]string{}},
+			false,
+		},
+		{url.Values{"status": {"200"}}, Link{}, true},
+		{url.Values{"meta": {"owner"}}, Link{}, true},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/admin/new", strings.NewReader(tt.form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			l, _, err := formLink(r)
+			if (err != nil) != tt.isErr {
+				t.Fatalf("got error %v want error %t", err, tt.isErr)
+			}
+			if tt.isErr {
+				return
+			}
+			if fmt.Sprint(l) != fmt.Sprint(tt.link) {
+				t.Errorf("got %+v want %+v", l, tt.link)
+			}
+			if f := linkFormOf(l); f.Disabled != linkDisabled(l) || strings.Contains(f.Meta, metaDisabled) {
+				t.Errorf("unexpected form %+v", f)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// api is a json api for managing links at runtime under /api/v1/links.
+// Links are validated in the same way as those in a csv file, and
+// changes are written to the server's store, which saves them to the
+// data file if the server is using one. Tokens with the admin scope
+// may also list and end admin console sessions under /api/v1/sessions.
+// Requests are authenticated by the auth middleware.
+
+// apiPrefix is the path of the links api
+const apiPrefix = "/api/v1/links"
+
+// apiSessionsPrefix is the path of the admin console sessions api
+const apiSessionsPrefix = "/api/v1/sessions"
+
+// maxAPIBody is the largest request body accepted by the api
+const maxAPIBody = 64 << 10
+
+// apiError is the body of an api error response
+type apiError struct {
+	Error string `json:"error"`
+}
+
+// writeAPI writes v as json with the given status
+func writeAPI(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if v == nil {
+		return
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Printf("api response error: %v", err)
+	}
+}
+
+// apiFail writes an api error response
+func apiFail(w http.ResponseWriter, status int, format string, a ...any) {
+	writeAPI(w, status, apiError{fmt.Sprintf(format, a...)})
+}
+
+// readAPILink decodes a link from a request body
+func readAPILink(w http.ResponseWriter, r *http.Request) (Link, error) {
+	var l Link
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxAPIBody))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&l); err != nil {
+		return l, fmt.Errorf("invalid link json: %v", err)
+	}
+	return l, nil
+}
+
+// apiList lists all links
+func (s *server) apiList(w http.ResponseWriter, r *http.Request) {
+	links, err := s.store.List(r.Context())
+	if err != nil {
+		log.Printf("api list error: %v", err)
+		apiFail(w, http.StatusInternalServerError, "could not list links")
+		return
+	}
+	writeAPI(w, http.StatusOK, links)
+}
+
+// apiGet gets a link
+func (s *server) apiGet(w http.ResponseWriter, r *http.Request) {
+	shortURL := r.PathValue("shortURL")
+	l, err := s.store.Lookup(r.Context(), shortURL)
+	switch {
+	case errors.Is(err, linkNotFoundError):
+		apiFail(w, http.StatusNotFound, "short url %s not found", shortURL)
+	case err != nil:
+		log.Printf("api lookup error for %s: %v", shortURL, err)
+		apiFail(w, http.StatusInternalServerError, "could not get link")
+	default:
+		writeAPI(w, http.StatusOK, l)
+	}
+}
+
+// apiCreate creates a link, which must not already exist
+func (s *server) apiCreate(w http.ResponseWriter, r *http.Request) {
+	l, err := readAPILink(w, r)
+	if err != nil {
+		apiFail(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	l, err = s.createLink(r.Context(), l, "api token "+tokenName(r))
+	switch {
+	case errors.Is(err, invalidLinkError):
+		apiFail(w, http.StatusBadRequest, "%v", err)
+	case errors.Is(err, linkExistsError):
+		apiFail(w, http.StatusConflict, "short url %s already exists", l.Short)
+	case err != nil:
+		log.Printf("api create error for %s: %v", l.Short, err)
+		apiFail(w, http.StatusInternalServerError, "could not create link")
+	default:
+		w.Header().Set("Location", apiPrefix+"/"+l.Short)
+		writeAPI(w, http.StatusCreated, l)
+	}
+}
+
+// apiUpdate replaces an existing link. The short url in the body, if
+// given, must match the path.
+func (s *server) apiUpdate(w http.ResponseWriter, r *http.Request) {
+	shortURL := r.PathValue("shortURL")
+	l, err := readAPILink(w, r)
+	if err != nil {
+		apiFail(w, http.StatusBadRequest, "%v", err)
+		return
+	}
+	if l.Short == "" {
+		l.Short = shortURL
+	}
+	if su, _ := cleanShort(l.Short); su != shortURL {
+		apiFail(w, http.StatusBadRequest, "short url %s does not match %s", l.Short, shortURL)
+		return
+	}
+	l, err = s.updateLink(r.Context(), l, "api token "+tokenName(r))
+	switch {
+	case errors.Is(err, invalidLinkError):
+		apiFail(w, http.StatusBadRequest, "%v", err)
+	case errors.Is(err, linkNotFoundError):
+		apiFail(w, http.StatusNotFound, "short url %s not found", shortURL)
+	case err != nil:
+		log.Printf("api update error for %s: %v", shortURL, err)
+		apiFail(w, http.StatusInternalServerError, "could not update link")
+	default:
+		writeAPI(w, http.StatusOK, l)
+	}
+}
+
+// apiDelete deletes a link
+func (s *server) apiDelete(w http.ResponseWriter, r *http.Request) {
+	shortURL := r.PathValue("shortURL")
+	err := s.deleteLink(r.Context(), shortURL, "api token "+tokenName(r))
+	switch {
+	case errors.Is(err, linkNotFoundError):
+		apiFail(w, http.StatusNotFound, "short url %s not found", shortURL)
+	case err != nil:
+		log.Printf("api delete error for %s: %v", shortURL, err)
+		apiFail(w, http.StatusInternalServerError, "could not delete link")
+	default:
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// apiSessions lists the admin console sessions
+func (s *server) apiSessions(w http.ResponseWriter, r *http.Request) {
+	writeAPI(w, http.StatusOK, s.sessions.list(time.Now()))
+}
+
+// apiEndSessions ends the admin console sessions started with a token
+func (s *server) apiEndSessions(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("token")
+	n := s.sessions.removeToken(name)
+	if n == 0 {
+		apiFail(w, http.StatusNotFound, "token %s has no sessions", name)
+		return
+	}
+	log.Printf("api token %s ended %d admin session(s) of token %s", tokenName(r), n, name)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAPI(t *testing.T) {
+	path := tmpLinkFile(t, "def,https://def\nabc,https://abc,302\n")
+	tokens, secrets := testTokens(t, map[string]string{"editor": "link-write"})
+	ns, err := newServer(false, "", "", 0, 0, withDataFile(path, time.Second), withAPI(), withTokens(tokens))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := ns.authenticate(ns.mux())
+	request := func(method, path, body string) *http.Request {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer "+secrets["editor"])
+		return r
+	}
+
+	tests := []struct {
+		method, path, body string
+		status             int
+		contains           string
+	}{
+		{"GET", "/api/v1/links", "", 200, `"short": "abc"`},
+		{"GET", "/api/v1/links/abc", "", 200, `"status": 302`},
+		{"GET", "/api/v1/links/nope", "", 404, "short url nope not found"},
+		{"POST", "/api/v1/links", `{"short": "ghi/", "target": " https://ghi ", "meta": {"owner": "rory"}}`, 201, `"target": "https://ghi"`},
+		{"POST", "/api/v1/links", `{"short": "ghi", "target": "https://other"}`, 409, "already exists"},
+		{"POST", "/api/v1/links", `{"target": "https://generated"}`, 201, `"target": "https://generated"`},
+		{"POST", "/api/v1/links", `{"short": "a b", "target": "https://ab"}`, 400, "has a space"},
+		{"POST", "/api/v1/links", `{"short": "a_b", "target": "https://ab"}`, 400, "invalid characters"},
+		{"POST", "/api/v1/links", `{"short": "jkl", "target": "jkl"}`, 400, "does not start with http"},
+		{"POST", "/api/v1/links", `{"short": "jkl", "target": "https://jkl", "status": 200}`, 400, "redirect status"},
+		{"POST", "/api/v1/links", `{"short": "jkl", "url": "https://jkl"}`, 400, "unknown field"},
+		{"POST", "/api/v1/links", `{"short": `, 400, "invalid link json"},
+		{"PUT", "/api/v1/links/abc", `{"target": "https://abc/new"}`, 200, `"short": "abc"`},
+		{"PUT", "/api/v1/links/abc", `{"short": "def", "target": "https://abc/new"}`, 400, "does not match"},
+		{"PUT", "/api/v1/links/nope", `{"target": "https://nope"}`, 404, "not found"},
+		{"DELETE", "/api/v1/links/def", "", 204, ""},
+		{"DELETE", "/api/v1/links/def", "", 404, "not found"},
+	}
+	for i, tt := range tests {
+		// in order, as each test changes the links
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, request(tt.method, tt.path, tt.body))
+			if rec.Code != tt.status {
+				t.Errorf("%s %s status got %d want %d: %s", tt.method, tt.path, rec.Code, tt.status, rec.Body)
+			}
+			if !strings.Contains(rec.Body.String(), tt.contains) {
+				t.Errorf("%s %s body does not contain %s: %s", tt.method, tt.path, tt.contains, rec.Body)
+			}
+			if rec.Code == 201 {
+				var l Link
+				if err := json.Unmarshal(rec.Body.Bytes(), &l); err != nil {
+					t.Fatal(err)
+				}
+				if len(l.Short) != defaultCodeLength && l.Short != "ghi" {
+					t.Errorf("unexpected short url %s", l.Short)
+				}
+				if got, want := rec.Header().Get("Location"), "/api/v1/links/"+l.Short; got != want {
+					t.Errorf("location got %s want %s", got, want)
+				}
+				if l.Short != "ghi" {
+					if err := ns.deleteLink(context.Background(), l.Short, "test"); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+
+	// changes are saved, keeping the order of the file
+	want := "short,target,status,owner\nabc,https://abc/new,,\nghi,https://ghi,,rory\n"
+	if got := readLinkFile(t, path); got != want {
+		t.Errorf("file got\n%s\nwant\n%s", got, want)
+	}
+
+	// and served
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, request("GET", "/api/v1/links", ""))
+	var links []Link
+	if err := json.Unmarshal(rec.Body.Bytes(), &links); err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 2 || links[0].Short != "abc" || links[1].Short != "ghi" {
+		t.Errorf("unexpected links %+v", links)
+	}
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/abc", nil))
+	if got, want := rec.Header().Get("Location"), "https://abc/new"; got != want {
+		t.Errorf("redirect got %s want %s", got, want)
+	}
+}
+
+func TestAPIDisabled(t *testing.T) {
+	ns, err := newServer(false, "", "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	ns.authenticate(ns.mux()).ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/links", nil))
+	if got, want := rec.Code, http.StatusNotFound; got != want {
+		t.Errorf("status got %d want %d", got, want)
+	}
+
+	// the api is not served without tokens
+	_, err = newServer(false, "", "", 0, 0, withAPI())
+	if err == nil {
+		t.Error("expected error for api without tokens")
+	}
+}
+
+// TestAPISessions checks that admin tokens can list and end admin
+// console sessions
+func TestAPISessions(t *testing.T) {
+	tokens, secrets := testTokens(t, map[string]string{"boss": "admin"})
+	ns, err := newServer(false, "", "", 0, 0, withAPI(), withAdmin(), withTokens(tokens))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := ns.authenticate(ns.mux())
+	editor := apiToken{Name: "editor", Scopes: []string{scopeLinkWrite}}
+	id, _, err := ns.sessions.create(editor, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method, path string
+		status       int
+		contains     string
+	}{
+		{"GET", "/api/v1/sessions", 200, `"token": "editor"`},
+		{"DELETE", "/api/v1/sessions/editor", 204, ""},
+		{"DELETE", "/api/v1/sessions/editor", 404, "has no sessions"},
+		{"GET", "/api/v1/sessions", 200, "[]"},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			r.Header.Set("Authorization", "Bearer "+secrets["boss"])
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, r)
+			if rec.Code != tt.status || !strings.Contains(rec.Body.String(), tt.contains) {
+				t.Errorf("%s %s got %d %s want %d %s", tt.method, tt.path, rec.Code, rec.Body, tt.status, tt.contains)
+			}
+		})
+	}
+	if _, ok := ns.sessions.get(id, time.Now()); ok {
+		t.Error("ended session found")
+	}
+}
+
+// TestNormalisedDelete checks that links are deleted by a short url
+// which is found once normalised, by the api and the admin console
+func TestNormalisedDelete(t *testing.T) {
+	path := tmpLinkFile(t, "def,https://def\nabc,https://abc\nghi,https://ghi\n")
+	tokens, secrets := testTokens(t, map[string]string{"editor": "link-write"})
+	ns, err := newServer(false, "", "", 0, 0,
+		withDataFile(path, time.Second), withAPI(), withAdmin(), withTokens(tokens),
+		withNormalise(normaliser{foldCase: true}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := ns.authenticate(ns.mux())
+
+	r := httptest.NewRequest("DELETE", "/api/v1/links/DEF", nil)
+	r.Header.Set("Authorization", "Bearer "+secrets["editor"])
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, r)
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("api delete got %d: %s", rec.Code, rec.Body)
+	}
+
+	c := &adminClient{t: t, handler: mux, cookies: map[string]*http.Cookie{}}
+	if rec := c.login(secrets["editor"]); rec.Code != http.StatusSeeOther {
+		t.Fatalf("login got %d: %s", rec.Code, rec.Body)
+	}
+	c.do("GET", "/admin/links/Abc", nil) // for the session's csrf value
+	if rec := c.do("POST", "/admin/links/Abc/delete", url.Values{"csrf": {c.csrf}}); rec.Code != http.StatusSeeOther {
+		t.Errorf("admin delete got %d: %s", rec.Code, rec.Body)
+	}
+
+	if got, want := readLinkFile(t, path), "ghi,https://ghi\n"; got != want {
+		t.Errorf("file got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auth authenticates requests to the api with bearer tokens. Tokens are
+// configured by name with a set of scopes, and only the sha256 hash of
+// each token is kept, so a tokens file does not hold the secrets
+// themselves. Failed attempts are logged and, if repeated, the client
+// is refused for a time.
+
+var InvalidTokenError error = errors.New("invalid token")
+
+// tokensEnv is the environment variable which may hold tokens
+const tokensEnv = "URL_SHORTENER_TOKENS"
+
+// scopes
+const (
+	scopeRead      = "read"       // read links
+	scopeLinkWrite = "link-write" // create, change and delete links
+	scopeAdmin     = "admin"      // everything, and managing sessions
+)
+
+// scopeImplies lists the scopes granted by each scope
+var scopeImplies = map[string][]string{
+	scopeRead:      {scopeRead},
+	scopeLinkWrite: {scopeLinkWrite, scopeRead},
+	scopeAdmin:     {scopeAdmin, scopeLinkWrite, scopeRead},
+}
+
+// failed auth attempt limits
+const (
+	maxAuthFailures   = 5
+	authFailureWindow = time.Minute
+)
+
+// apiToken is a named token and its scopes
+type apiToken struct {
+	Name   string
+	Scopes []string
+}
+
+// allows reports if the token grants scope
+func (t apiToken) allows(scope string) bool {
+	for _, s := range t.Scopes {
+		if slices.Contains(scopeImplies[s], scope) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenSet holds tokens by the sha256 hash of the token
+type tokenSet struct {
+	tokens map[[sha256.Size]byte]apiToken
+}
+
+// hashToken returns the sha256 hash of a token
+func hashToken(token string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(token))
+}
+
+// parseTokens reads tokens in "name scopes hash" format, one per line,
+// where scopes are separated by commas and hash is the hex encoded
+// sha256 hash of the token. Blank lines and lines starting with "#" are
+// ignored. source names the tokens in errors.
+func (ts *tokenSet) parseTokens(r io.Reader, source string) error {
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return fmt.Errorf("%s line %d: expected name, scopes and hash", source, line)
+		}
+		name, scopes := fields[0], strings.Split(fields[1], ",")
+		for _, s := range scopes {
+			if _, ok := scopeImplies[s]; !ok {
+				return fmt.Errorf("%s line %d: token %s has unknown scope %q", source, line, name, s)
+			}
+		}
+		b, err := hex.DecodeString(fields[2])
+		if err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("%s line %d: token %s hash is not a hex sha256 hash", source, line, name)
+		}
+		hash := [sha256.Size]byte(b)
+		if existing, ok := ts.tokens[hash]; ok {
+			return fmt.Errorf("%s line %d: token %s has the same hash as %s", source, line, name, existing.Name)
+		}
+		ts.tokens[hash] = apiToken{Name: name, Scopes: scopes}
+	}
+	return scanner.Err()
+}
+
+// loadTokens loads tokens from a file and from an environment variable
+// value, in which tokens may be separated by newlines or semicolons.
+// Either may be empty. A nil tokenSet is returned if no tokens are
+// configured.
+func loadTokens(path, env string) (*tokenSet, error) {
+	ts := &tokenSet{tokens: map[[sha256.Size]byte]apiToken{}}
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not open tokens file: %v", err)
+		}
+		defer f.Close()
+		if err := ts.parseTokens(f, path); err != nil {
+			return nil, err
+		}
+	}
+	if env != "" {
+		err := ts.parseTokens(strings.NewReader(strings.ReplaceAll(env, ";", "\n")), tokensEnv)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(ts.tokens) == 0 {
+		return nil, nil
+	}
+	return ts, nil
+}
+
+// authenticate returns the token for a bearer token
+func (ts *tokenSet) authenticate(bearer string) (apiToken, error) {
+	if ts == nil || bearer == "" {
+		return apiToken{}, InvalidTokenError
+	}
+	t, ok := ts.tokens[hashToken(bearer)]
+	if !ok {
+		return apiToken{}, InvalidTokenError
+	}
+	return t, nil
+}
+
+// authLimiter counts failed auth attempts by client, refusing clients
+// with too many recent failures
+type authLimiter struct {
+	max      int
+	window   time.Duration
+	mu       sync.Mutex
+	failures map[string]*authFailures
+}
+
+// authFailures records a client's failed attempts in the current window
+type authFailures struct {
+	count int
+	start time.Time
+}
+
+// newAuthLimiter makes an authLimiter allowing max failures per window
+func newAuthLimiter(max int, window time.Duration) *authLimiter {
+	return &authLimiter{max: max, window: window, failures: map[string]*authFailures{}}
+}
+
+// blocked reports if the client has had too many recent failures
+func (a *authLimiter) blocked(client string, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	f, ok := a.failures[client]
+	return ok && now.Sub(f.start) < a.window && f.count >= a.max
+}
+
+// fail records a failed attempt by client, returning the number of
+// failures in the current window
+func (a *authLimiter) fail(client string, now time.Time) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for c, f := range a.failures {
+		if now.Sub(f.start) >= a.window {
+			delete(a.failures, c)
+		}
+	}
+	f, ok := a.failures[client]
+	if !ok {
+		f = &authFailures{start: now}
+		a.failures[client] = f
+	}
+	f.count++
+	return f.count
+}
+
+// tokenKey is the request context key for the authenticated token
+type tokenKey struct{}
+
+// tokenName returns the name of the token that authenticated a
+// request, or "-" if there is none
+func tokenName(r *http.Request) string {
+	if t, ok := r.Context().Value(tokenKey{}).(apiToken); ok {
+		return t.Name
+	}
+	return "-"
+}
+
+// requiredScope returns the scope needed for a request, or an empty
+// string if the request needs no authentication
+func (s *server) requiredScope(r *http.Request) string {
+	under := func(prefix string) bool {
+		return r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/")
+	}
+	switch {
+	case !s.api:
+		return ""
+	case under(apiSessionsPrefix):
+		return scopeAdmin
+	case !under(apiPrefix):
+		return ""
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return scopeRead
+	}
+	return scopeLinkWrite
+}
+
+// clientAddr returns the address of the client making a request
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// authenticate is middleware requiring a bearer token with the scope
+// needed for each request
+func (s *server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope := s.requiredScope(r)
+		if scope == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		client, now := clientAddr(r), time.Now()
+		if s.authFails.blocked(client, now) {
+			log.Printf("auth refused for %s %s: too many failed attempts from %s", r.Method, r.URL.Path, client)
+			w.Header().Set("Retry-After", fmt.Sprint(int(s.authFails.window.Seconds())))
+			apiFail(w, http.StatusTooManyRequests, "too many failed authentication attempts")
+			return
+		}
+
+		bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		t, err := s.tokens.authenticate(strings.TrimSpace(bearer))
+		if !ok || err != nil {
+			n := s.authFails.fail(client, now)
+			log.Printf("auth failed for %s %s from %s: missing or invalid token (%d recent failures)", r.Method, r.URL.Path, client, n)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="url-shortener"`)
+			apiFail(w, http.StatusUnauthorized, "a valid bearer token is required")
+			return
+		}
+		if !t.allows(scope) {
+			log.Printf("auth denied for %s %s: token %s lacks %s scope", r.Method, r.URL.Path, t.Name, scope)
+			apiFail(w, http.StatusForbidden, "token %s lacks the %s scope", t.Name, scope)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenKey{}, t)))
+	})
+}
+
+// newToken makes a random token
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// tokenCommand is the "token" command
+type tokenCommand struct {
+	Name   string   `short:"n" long:"name" required:"yes" description:"token name, used in logs"`
+	Scopes []string `short:"s" long:"scope" default:"read" choice:"read" choice:"link-write" choice:"admin" description:"token scope, may be repeated"`
+}
+
+// Execute makes a new token, printing it and the line to add to a
+// tokens file
+func (tc *tokenCommand) Execute(args []string) error {
+	if strings.ContainsAny(tc.Name, " \t") {
+		return errors.New("token name has a space")
+	}
+	token, err := newToken()
+	if err != nil {
+		return fmt.Errorf("could not make token: %v", err)
+	}
+	hash := hashToken(token)
+	fmt.Fprintf(output, "token (keep this secret, it is not stored): %s\n", token)
+	fmt.Fprintf(output, "tokens file line:\n%s %s %s\n", tc.Name, strings.Join(tc.Scopes, ","), hex.EncodeToString(hash[:]))
+	return nil
+}
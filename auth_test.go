@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testTokens makes a tokenSet from a map of token names to scopes,
+// returning it with a map of token names to tokens
+func testTokens(t *testing.T, scopes map[string]string) (*tokenSet, map[string]string) {
+	t.Helper()
+	secrets := map[string]string{}
+	lines := []string{}
+	for name, s := range scopes {
+		secrets[name] = "secret-" + name
+		hash := hashToken(secrets[name])
+		lines = append(lines, fmt.Sprintf("%s %s %s", name, s, hex.EncodeToString(hash[:])))
+	}
+	ts, err := loadTokens("", strings.Join(lines, ";"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ts, secrets
+}
+
+func TestLoadTokens(t *testing.T) {
+	hash := func(token string) string {
+		h := hashToken(token)
+		return hex.EncodeToString(h[:])
+	}
+	path := filepath.Join(t.TempDir(), "tokens")
+	err := os.WriteFile(path, []byte("# ci tokens\n\nci read "+hash("a")+"\nbot link-write,read "+hash("b")+"\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path, env string
+		tokens    int
+		isErr     bool
+	}{
+		{"", "", 0, false},
+		{path, "", 2, false},
+		{path, "root admin " + hash("c"), 3, false},
+		{"", "x read " + hash("x") + "; y admin " + hash("y"), 2, false},
+		{path, "dup admin " + hash("a"), 0, true},            // same token
+		{"", "x read", 0, true},                              // no hash
+		{"", "x write " + hash("x"), 0, true},                // unknown scope
+		{"", "x read " + hash("x")[:10], 0, true},            // short hash
+		{"", "x read not-a-hash-" + hash("x")[18:], 0, true}, // not hex
+		{filepath.Join(t.TempDir(), "missing"), "", 0, true},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			ts, err := loadTokens(tt.path, tt.env)
+			if (err != nil) != tt.isErr {
+				t.Fatalf("got error %v want error %t", err, tt.isErr)
+			}
+			if tt.tokens == 0 {
+				if ts != nil && !tt.isErr {
+					t.Errorf("expected no tokens, got %v", ts.tokens)
+				}
+				return
+			}
+			if got := len(ts.tokens); got != tt.tokens {
+				t.Errorf("got %d tokens want %d", got, tt.tokens)
+			}
+		})
+	}
+}
+
+func TestTokenScopes(t *testing.T) {
+	tests := []struct {
+		scopes []string
+		scope  string
+		allows bool
+	}{
+		{[]string{scopeRead}, scopeRead, true},
+		{[]string{scopeRead}, scopeLinkWrite, false},
+		{[]string{scopeLinkWrite}, scopeRead, true},
+		{[]string{scopeLinkWrite}, scopeAdmin, false},
+		{[]string{scopeAdmin}, scopeLinkWrite, true},
+		{[]string{scopeRead, scopeAdmin}, scopeAdmin, true},
+		{nil, scopeRead, false},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			if got := (apiToken{Scopes: tt.scopes}).allows(tt.scope); got != tt.allows {
+				t.Errorf("%v allows %s got %t want %t", tt.scopes, tt.scope, got, tt.allows)
+			}
+		})
+	}
+}
+
+func TestAuthLimiter(t *testing.T) {
+	a := newAuthLimiter(2, time.Minute)
+	now := time.Now()
+	if a.blocked("x", now) {
+		t.Error("blocked before failures")
+	}
+	a.fail("x", now)
+	if a.blocked("x", now) {
+		t.Error("blocked after one failure")
+	}
+	if n := a.fail("x", now.Add(time.Second)); n != 2 {
+		t.Errorf("got %d failures want 2", n)
+	}
+	if !a.blocked("x", now.Add(2*time.Second)) {
+		t.Error("not blocked after two failures")
+	}
+	if a.blocked("y", now) {
+		t.Error("other client blocked")
+	}
+	if a.blocked("x", now.Add(time.Minute)) {
+		t.Error("blocked after the window")
+	}
+	a.fail("y", now.Add(2*time.Minute))
+	if _, ok := a.failures["x"]; ok {
+		t.Error("expired failures not pruned")
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	tokens, secrets := testTokens(t, map[string]string{
+		"reader": "read",
+		"editor": "link-write",
+		"boss":   "admin",
+	})
+	ns, err := newServer(false, "", "", 0, 0, withAPI(), withTokens(tokens))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.authFails = newAuthLimiter(3, time.Minute)
+	handler := ns.authenticate(ns.mux())
+
+	tests := []struct {
+		method, path, auth, client string
+		status                     int
+	}{
+		{"GET", "/dbd", "", "10.0.0.1", 301}, // redirects are public
+		{"GET", "/api/v1/links", "Bearer " + secrets["reader"], "10.0.0.1", 200},
+		{"GET", "/api/v1/links/dbd", "Bearer " + secrets["editor"], "10.0.0.1", 200},
+		{"DELETE", "/api/v1/links/dbd", "Bearer " + secrets["reader"], "10.0.0.1", 403},
+		{"DELETE", "/api/v1/links/nope", "Bearer " + secrets["editor"], "10.0.0.1", 404},
+		{"GET", "/api/v1/sessions", "Bearer " + secrets["editor"], "10.0.0.1", 403},
+		{"GET", "/api/v1/sessions", "Bearer " + secrets["boss"], "10.0.0.1", 200},
+		{"DELETE", "/api/v1/sessions/editor", "Bearer " + secrets["editor"], "10.0.0.1", 403},
+		{"DELETE", "/api/v1/sessions/editor", "Bearer " + secrets["boss"], "10.0.0.1", 404}, // no sessions
+		{"GET", "/api/v1/links", "", "10.0.0.2", 401},
+		{"GET", "/api/v1/links", "Bearer wrong", "10.0.0.2", 401},
+		{"GET", "/api/v1/links", secrets["reader"], "10.0.0.2", 401}, // not bearer
+		{"GET", "/api/v1/links", "Bearer " + secrets["reader"], "10.0.0.2", 429},
+		{"GET", "/dbd", "", "10.0.0.2", 301},
+		{"GET", "/api/v1/links", "Bearer " + secrets["reader"], "10.0.0.3", 200},
+	}
+	for i, tt := range tests {
+		// in order, as failures are counted
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			r.RemoteAddr = tt.client + ":1234"
+			if tt.auth != "" {
+				r.Header.Set("Authorization", tt.auth)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+			if rec.Code != tt.status {
+				t.Errorf("%s %s status got %d want %d: %s", tt.method, tt.path, rec.Code, tt.status, rec.Body)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("no WWW-Authenticate header")
+			}
+		})
+	}
+}
+
+func TestTokenCommand(t *testing.T) {
+	buf := commandOutput(t)
+	tc := tokenCommand{Name: "ci", Scopes: []string{"read", "link-write"}}
+	if err := tc.Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("unexpected output %q", buf.String())
+	}
+	token := lines[0][strings.LastIndex(lines[0], " ")+1:]
+	ts, err := loadTokens("", lines[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ts.authenticate(token)
+	if err != nil || got.Name != "ci" || !got.allows(scopeLinkWrite) {
+		t.Errorf("token %s did not authenticate: %v %+v", token, err, got)
+	}
+
+	if err := (&tokenCommand{Name: "a b", Scopes: []string{"read"}}).Execute(nil); err == nil {
+		t.Error("expected error for name with a space")
+	}
+}
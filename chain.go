@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// chain inspects the redirect chain followed when checking a url,
+// flagging chains which may mean a target has moved or been lost: those
+// crossing to a different registrable domain, those longer than a
+// maximum number of hops and those ending at the root of a site.
+
+var CrossDomainRedirect error = errors.New("redirect to a different domain")
+var TooManyRedirects error = errors.New("too many redirects")
+var RootRedirect error = errors.New("redirect to site root")
+
+// secondLevelDomains are common second level labels under country code
+// top level domains, such as the "co" of "co.uk". In the absence of
+// the public suffix list these are used to find the registrable domain.
+var secondLevelDomains = map[string]bool{
+	"ac": true, "co": true, "com": true, "edu": true, "gov": true,
+	"ltd": true, "me": true, "net": true, "nhs": true, "org": true,
+	"plc": true, "sch": true,
+}
+
+// registrableDomain returns the registrable part of a host name, for
+// example "example.co.uk" for "www.example.co.uk". IP addresses are
+// returned unchanged.
+func registrableDomain(host string) string {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return host
+	}
+	labels := strings.Split(host, ".")
+	n := len(labels)
+	if n <= 2 {
+		return host
+	}
+	if len(labels[n-1]) == 2 && secondLevelDomains[labels[n-2]] {
+		return strings.Join(labels[n-3:], ".")
+	}
+	return strings.Join(labels[n-2:], ".")
+}
+
+// isRoot reports if a url is the root of a site, without a path or query
+func isRoot(u *url.URL) bool {
+	return (u.Path == "" || u.Path == "/") && u.RawQuery == ""
+}
+
+// traceRedirects checks the redirect chain of a result, returning an
+// error joining each problem found. maxHops of 0 means the length of the
+// chain is not checked.
+func traceRedirects(r checkResult, maxHops int) error {
+	if len(r.Redirects) == 0 || r.FinalURL == "" {
+		return nil
+	}
+	start, err := url.Parse(r.URL)
+	if err != nil {
+		return nil
+	}
+	final, err := url.Parse(r.FinalURL)
+	if err != nil {
+		return nil
+	}
+
+	var errs []error
+	if from, to := registrableDomain(start.Host), registrableDomain(final.Host); from != to {
+		errs = append(errs, fmt.Errorf("%w: %s to %s", CrossDomainRedirect, from, to))
+	}
+	if maxHops > 0 && len(r.Redirects) > maxHops {
+		errs = append(errs, fmt.Errorf("%w: %d hops, more than %d", TooManyRedirects, len(r.Redirects), maxHops))
+	}
+	if isRoot(final) && !isRoot(start) {
+		errs = append(errs, fmt.Errorf("%w: %s", RootRedirect, r.FinalURL))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRegistrableDomain(t *testing.T) {
+	tests := []struct {
+		host, domain string
+	}{
+		{"example.com", "example.com"},
+		{"www.example.com", "example.com"},
+		{"a.b.example.com", "example.com"},
+		{"WWW.Example.COM.", "example.com"},
+		{"www.example.com:8080", "example.com"},
+		{"www.gov.uk", "www.gov.uk"},
+		{"assets.publishing.service.gov.uk", "service.gov.uk"},
+		{"www.amazon.co.uk", "amazon.co.uk"},
+		{"example.io", "example.io"},
+		{"localhost", "localhost"},
+		{"127.0.0.1:8000", "127.0.0.1"},
+		{"[::1]:8000", "::1"},
+	}
+	for _, tt := range tests {
+		if got := registrableDomain(tt.host); got != tt.domain {
+			t.Errorf("%s got %s want %s", tt.host, got, tt.domain)
+		}
+	}
+}
+
+func TestTraceRedirects(t *testing.T) {
+	hops := func(n int) []hop {
+		h := make([]hop, n)
+		for i := range h {
+			h[i] = hop{"https://example.com/x", 301}
+		}
+		return h
+	}
+	tests := []struct {
+		name    string
+		result  checkResult
+		maxHops int
+		errs    []error
+	}{
+		{
+			name:   "no redirects",
+			result: checkResult{URL: "https://example.com/a", FinalURL: "https://example.com/a"},
+		},
+		{
+			name:   "https upgrade and www",
+			result: checkResult{URL: "http://example.com/a", Redirects: hops(2), FinalURL: "https://www.example.com/a/"},
+		},
+		{
+			name:   "domain squatter",
+			result: checkResult{URL: "https://example.com/a", Redirects: hops(1), FinalURL: "https://squatter.net/landing"},
+			errs:   []error{CrossDomainRedirect},
+		},
+		{
+			name:    "too many hops",
+			result:  checkResult{URL: "https://example.com/a", Redirects: hops(4), FinalURL: "https://example.com/b"},
+			maxHops: 3,
+			errs:    []error{TooManyRedirects},
+		},
+		{
+			name:   "hops unchecked",
+			result: checkResult{URL: "https://example.com/a", Redirects: hops(4), FinalURL: "https://example.com/b"},
+		},
+		{
+			name:   "root",
+			result: checkResult{URL: "https://example.com/a", Redirects: hops(1), FinalURL: "https://example.com/"},
+			errs:   []error{RootRedirect},
+		},
+		{
+			name:   "root to root",
+			result: checkResult{URL: "https://example.com", Redirects: hops(1), FinalURL: "https://www.example.com/"},
+		},
+		{
+			name:    "all",
+			result:  checkResult{URL: "https://example.com/a?b=c", Redirects: hops(2), FinalURL: "https://parked.example.org"},
+			maxHops: 1,
+			errs:    []error{CrossDomainRedirect, TooManyRedirects, RootRedirect},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := traceRedirects(tt.result, tt.maxHops)
+			if len(tt.errs) == 0 && err != nil {
+				t.Errorf("unexpected error %v", err)
+			}
+			for _, want := range tt.errs {
+				if !errors.Is(err, want) {
+					t.Errorf("expected %v in %v", want, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package main
+
+import "sync"
+
+// clicks counts the redirects made for each short url since the server
+// started. Counts are held in memory only.
+
+// clickCounts is a concurrency safe count of clicks by short url
+type clickCounts struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+}
+
+// newClickCounts makes a clickCounts
+func newClickCounts() *clickCounts {
+	return &clickCounts{counts: map[string]uint64{}}
+}
+
+// add counts a click on a short url
+func (c *clickCounts) add(shortURL string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[shortURL]++
+}
+
+// get returns the clicks on a short url
+func (c *clickCounts) get(shortURL string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.counts[shortURL]
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestClickCounts(t *testing.T) {
+	c := newClickCounts()
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.add("abc")
+		}()
+	}
+	wg.Wait()
+	if got := c.get("abc"); got != 10 {
+		t.Errorf("got %d clicks want 10", got)
+	}
+	if got := c.get("def"); got != 0 {
+		t.Errorf("got %d clicks want 0", got)
+	}
+}
+
+func TestRedirectClicks(t *testing.T) {
+	ns, err := newServer(false, "", "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{"/dbd", "/dbd", "/dbd+", "/dbd?preview=1", "/nope"} {
+		ns.mux().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	if got := ns.clicks.get("dbd"); got != 2 {
+		t.Errorf("got %d clicks want 2, not counting previews", got)
+	}
+}
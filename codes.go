@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// codes generates random short urls for links created without one. The
+// default alphabet leaves out characters which are easily confused, such
+// as 0 and O or 1 and l, and upper case letters, as short urls are often
+// read aloud or typed by hand. Codes containing the reserved names of
+// the server's routes or profanity are not used.
+
+var CodeExhaustedError error = errors.New("could not generate an unused short url")
+
+// code generation defaults
+const (
+	defaultCodeAlphabet = "23456789abcdefghjkmnpqrstuvwxyz"
+	defaultCodeLength   = 6
+	minCodeLength       = 3
+	maxCodeLength       = 32
+	maxCodeAttempts     = 20
+)
+
+// codeBlocklist holds words which generated codes may not contain,
+// ignoring case, other than the reserved names of the server's routes.
+// Words with i, l or o cannot be made from the default alphabet, but
+// are kept for custom alphabets.
+var codeBlocklist = []string{
+	"arse", "bitch", "cock", "crap", "cunt", "damn", "dick", "fag",
+	"fuck", "nazi", "nig", "piss", "porn", "rape", "sex", "shit",
+	"slut", "tit", "twat", "wank", "whore",
+}
+
+// codeGenerator makes random short urls of length characters from
+// alphabet
+type codeGenerator struct {
+	alphabet string
+	length   int
+	blocked  []string
+}
+
+// newCodeGenerator makes a codeGenerator, checking that the alphabet
+// only has characters valid in a short url. An empty alphabet or a
+// length of 0 uses the default.
+func newCodeGenerator(alphabet string, length int) (*codeGenerator, error) {
+	if alphabet == "" {
+		alphabet = defaultCodeAlphabet
+	}
+	if length == 0 {
+		length = defaultCodeLength
+	}
+	if length < minCodeLength || length > maxCodeLength {
+		return nil, fmt.Errorf("short url length %d is not between %d and %d", length, minCodeLength, maxCodeLength)
+	}
+	if len(alphabet) < 2 {
+		return nil, errors.New("short url alphabet needs at least two characters")
+	}
+	for i, c := range alphabet {
+		if !shortURLValidRegex.MatchString(string(c)) {
+			return nil, fmt.Errorf("short url alphabet character %q is not valid in a short url", c)
+		}
+		if strings.ContainsRune(alphabet[:i], c) {
+			return nil, fmt.Errorf("short url alphabet character %q is repeated", c)
+		}
+	}
+	return &codeGenerator{alphabet: alphabet, length: length, blocked: append(reserved(), codeBlocklist...)}, nil
+}
+
+// random makes a random code, which may be blocked
+func (g *codeGenerator) random() (string, error) {
+	var b strings.Builder
+	n := big.NewInt(int64(len(g.alphabet)))
+	for range g.length {
+		i, err := rand.Int(rand.Reader, n)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(g.alphabet[i.Int64()])
+	}
+	return b.String(), nil
+}
+
+// allowed reports if a code has no blocked words
+func (g *codeGenerator) allowed(code string) bool {
+	code = strings.ToLower(code)
+	for _, w := range g.blocked {
+		if strings.Contains(code, w) {
+			return false
+		}
+	}
+	return true
+}
+
+// generate makes a random code which is allowed and for which exists
+// reports false, trying again on collisions up to maxCodeAttempts times
+func (g *codeGenerator) generate(exists func(string) (bool, error)) (string, error) {
+	for range maxCodeAttempts {
+		code, err := g.random()
+		if err != nil {
+			return "", fmt.Errorf("could not make short url: %v", err)
+		}
+		if !g.allowed(code) {
+			continue
+		}
+		taken, err := exists(code)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return code, nil
+		}
+	}
+	return "", fmt.Errorf("%w after %d attempts, try a longer length", CodeExhaustedError, maxCodeAttempts)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNewCodeGenerator(t *testing.T) {
+	tests := []struct {
+		alphabet string
+		length   int
+		isErr    bool
+	}{
+		{defaultCodeAlphabet, defaultCodeLength, false},
+		{"", 0, false}, // defaults
+		{"", 3, false},
+		{"ab", 0, false},
+		{"ab", -1, true},
+		{"ab", 3, false},
+		{"ABC-", 32, false},
+		{"ab", 2, true},
+		{"ab", 33, true},
+		{"a", 6, true},
+		{"abca", 6, true},
+		{"ab_", 6, true},
+		{"ab/", 6, true},
+		{"abé", 6, true},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			_, err := newCodeGenerator(tt.alphabet, tt.length)
+			if (err != nil) != tt.isErr {
+				t.Errorf("got error %v want error %t", err, tt.isErr)
+			}
+		})
+	}
+
+	g, err := newCodeGenerator("", 0)
+	if err != nil || g.alphabet != defaultCodeAlphabet || g.length != defaultCodeLength {
+		t.Errorf("unexpected defaults %+v %v", g, err)
+	}
+}
+
+func TestDefaultCodeAlphabet(t *testing.T) {
+	for _, c := range "0Oo1lIi" {
+		if strings.ContainsRune(defaultCodeAlphabet, c) {
+			t.Errorf("ambiguous character %q in the default alphabet", c)
+		}
+	}
+}
+
+func TestGenerateCode(t *testing.T) {
+	g, err := newCodeGenerator(defaultCodeAlphabet, defaultCodeLength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for range 200 {
+		code, err := g.generate(func(c string) (bool, error) { return seen[c], nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != defaultCodeLength || !shortURLValidRegex.MatchString(code) || !g.allowed(code) {
+			t.Fatalf("invalid code %s", code)
+		}
+		for _, c := range code {
+			if !strings.ContainsRune(defaultCodeAlphabet, c) {
+				t.Fatalf("code %s has %q, not in the alphabet", code, c)
+			}
+		}
+		seen[code] = true
+	}
+
+	// collisions are retried
+	tries := 0
+	code, err := g.generate(func(c string) (bool, error) {
+		tries++
+		return tries < 3, nil
+	})
+	if err != nil || tries != 3 {
+		t.Errorf("got %s %v after %d tries, want 3", code, err, tries)
+	}
+
+	// until there are too many
+	_, err = g.generate(func(c string) (bool, error) { return true, nil })
+	if !errors.Is(err, CodeExhaustedError) {
+		t.Errorf("got %v want %v", err, CodeExhaustedError)
+	}
+
+	// lookup errors are returned
+	lookupErr := errors.New("lookup")
+	if _, err = g.generate(func(c string) (bool, error) { return false, lookupErr }); !errors.Is(err, lookupErr) {
+		t.Errorf("got %v want %v", err, lookupErr)
+	}
+
+	// blocked words are never used
+	g, _ = newCodeGenerator("ap", 3)
+	g.blocked = []string{"aa"}
+	for range 50 {
+		code, err := g.generate(func(c string) (bool, error) { return false, nil })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(code, "aa") {
+			t.Fatalf("blocked code %s", code)
+		}
+	}
+	g.blocked = []string{"a", "p"}
+	if _, err = g.generate(func(c string) (bool, error) { return false, nil }); !errors.Is(err, CodeExhaustedError) {
+		t.Errorf("got %v want %v", err, CodeExhaustedError)
+	}
+}
+
+func TestCodeAllowed(t *testing.T) {
+	g, _ := newCodeGenerator(defaultCodeAlphabet, defaultCodeLength)
+	tests := []struct {
+		code    string
+		allowed bool
+	}{
+		{"x7kq2m", true},
+		{"admin2", false},
+		{"zzAPIz", false},
+		{"shitty", false},
+		{"fucku2", false},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			if got := g.allowed(tt.code); got != tt.allowed {
+				t.Errorf("%s got %t want %t", tt.code, got, tt.allowed)
+			}
+		})
+	}
+}
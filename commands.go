@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// commands provides the command line operations on csv link files
+// other than serving, which is the default. Commands which change a
+// file validate it first and write it back atomically.
+
+// loadURLFile loads the links from a csv file, or from the embedded
+// data file if path is empty
+func loadURLFile(path string) (map[string]Link, error) {
+	var r io.ReadCloser
+	var err error
+	if path == "" {
+		r, err = dataFS.Open(dataPath + "/" + dataFile)
+	} else {
+		r, err = os.Open(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	m, err := validateURLs(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not load urls:\n%w", err)
+	}
+	return m, nil
+}
+
+// checkCommand is the "check" command
+type checkCommand struct {
+	Timeout      time.Duration `short:"t" long:"timeout" default:"5s" description:"url checker timeout"`
+	Workers      uint          `short:"w" long:"workers" default:"8" description:"url checker workers"`
+	Deadline     time.Duration `long:"deadline" description:"stop checking after this time, reporting the results so far"`
+	Sniff        bool          `long:"sniff" description:"inspect html pages for parked domains and login walls"`
+	MaxHops      uint          `long:"max-hops" default:"3" description:"flag redirect chains longer than this (0 to disable)"`
+	Retries      uint          `long:"retries" default:"2" description:"retries of timeouts, connection errors and 429, 502, 503 or 504 responses"`
+	Backoff      time.Duration `long:"backoff" default:"500ms" description:"wait before the first retry, doubled for each retry with jitter"`
+	PerHost      uint          `long:"per-host" default:"2" description:"concurrent requests per host (0 for no limit)"`
+	HostInterval time.Duration `long:"host-interval" description:"minimum time between requests to the same host"`
+	Format       string        `long:"format" default:"table" choice:"table" choice:"json" choice:"junit" choice:"redirects" description:"output format; redirects writes a csv of redirected links and their final urls"`
+	Args         struct {
+		File string `positional-arg-name:"file" description:"csv link file (default: the embedded file)"`
+	} `positional-args:"yes"`
+}
+
+// Execute checks the target of each link and reports the results,
+// returning an error if any fail
+func (c *checkCommand) Execute(args []string) error {
+	if c.Workers < 1 {
+		return errors.New("at least one worker is needed")
+	}
+	m, err := loadURLFile(c.Args.File)
+	if err != nil {
+		return err
+	}
+	links := sortedLinks(m)
+	targets := []string{}
+	seen := map[string]bool{}
+	for _, l := range links {
+		if !seen[l.Target] {
+			targets = append(targets, l.Target)
+			seen[l.Target] = true
+		}
+	}
+
+	// stop on interrupt or at the deadline, reporting partial results
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if c.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Deadline)
+		defer cancel()
+	}
+
+	g := NewGetClient(int(c.Workers), c.Timeout)
+	g.allowContentTypes(links)
+	g.sniff = c.Sniff
+	g.maxHops = int(c.MaxHops)
+	g.retry = retryPolicy{retries: int(c.Retries), backoff: c.Backoff, maxBackoff: maxRetryAfter}
+	g.hosts = newHostLimiter(int(c.PerHost), c.HostInterval)
+	results, checkErr := g.Check(ctx, targets)
+	checks := linkChecks(links, results)
+
+	switch c.Format {
+	case "json":
+		err = writeJSON(output, checks)
+	case "junit":
+		err = writeJUnit(output, checks)
+	case "redirects":
+		err = writeRedirects(output, checks)
+	default:
+		err = writeTable(output, checks)
+	}
+	if err != nil {
+		return err
+	}
+	if checkErr != nil {
+		return fmt.Errorf("check stopped after %d of %d urls: %w", len(results), len(targets), checkErr)
+	}
+	if n := failures(checks); n > 0 {
+		return fmt.Errorf("%d of %d link check(s) failed", n, len(checks))
+	}
+	return nil
+}
+
+// exportCommand is the "export" command
+type exportCommand struct {
+	Format string `long:"format" default:"csv" choice:"csv" choice:"json" description:"output format"`
+	Args   struct {
+		File string `positional-arg-name:"file" description:"csv link file (default: the embedded file)"`
+	} `positional-args:"yes"`
+}
+
+// Execute writes the links to output
+func (e *exportCommand) Execute(args []string) error {
+	m, err := loadURLFile(e.Args.File)
+	if err != nil {
+		return err
+	}
+	links := sortedLinks(m)
+	if e.Format == "json" {
+		enc := json.NewEncoder(output)
+		enc.SetIndent("", "  ")
+		return enc.Encode(links)
+	}
+	return writeURLs(output, links)
+}
+
+// importCommand is the "import" command
+type importCommand struct {
+	Format  string `long:"format" default:"csv" choice:"csv" choice:"json" description:"source format"`
+	Replace bool   `short:"r" long:"replace" description:"replace existing links with the same short url"`
+	Args    struct {
+		File   string `positional-arg-name:"file" required:"yes" description:"csv link file to import into"`
+		Source string `positional-arg-name:"source" required:"yes" description:"file of links to import, or - for stdin"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// Execute merges the links in the source into the file
+func (i *importCommand) Execute(args []string) error {
+	m, err := loadURLFile(i.Args.File)
+	if err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if i.Args.Source != "-" {
+		f, err := os.Open(i.Args.Source)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	var imported []Link
+	if i.Format == "json" {
+		imported, err = readJSONLinks(r)
+	} else {
+		var im map[string]Link
+		im, err = validateURLs(r)
+		imported = sortedLinks(im)
+	}
+	if err != nil {
+		return fmt.Errorf("could not read %s:\n%w", i.Args.Source, err)
+	}
+
+	added, replaced := 0, 0
+	for _, l := range imported {
+		existing, exists := m[l.Short]
+		if exists && !i.Replace {
+			return fmt.Errorf("short url %s already exists in %s", l.Short, i.Args.File)
+		}
+		if exists {
+			l.line = existing.line
+			replaced++
+		} else {
+			l.line = 0
+			added++
+		}
+		m[l.Short] = l
+	}
+	err = writeURLFile(i.Args.File, sortedLinks(m))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(output, "imported %d links (%d added, %d replaced)\n", added+replaced, added, replaced)
+	return nil
+}
+
+// readJSONLinks reads a json array of links, validating each as if it
+// were a csv record
+func readJSONLinks(r io.Reader) ([]Link, error) {
+	var links []Link
+	err := json.NewDecoder(r).Decode(&links)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for i, l := range links {
+		links[i], err = validateLink(l)
+		if err != nil {
+			return nil, fmt.Errorf("link %d: %w", i+1, err)
+		}
+		if seen[links[i].Short] {
+			return nil, fmt.Errorf("link %d: short url %s already exists", i+1, links[i].Short)
+		}
+		seen[links[i].Short] = true
+	}
+	return links, nil
+}
+
+// addCommand is the "add" command
+type addCommand struct {
+	Status   int    `short:"s" long:"status" description:"redirect status (301, 302, 307 or 308)"`
+	Length   int    `short:"l" long:"length" description:"length of a generated short url, instead of the default"`
+	Alphabet string `long:"alphabet" description:"characters of a generated short url, instead of the default"`
+	Args     struct {
+		File   string `positional-arg-name:"file" required:"yes" description:"csv link file"`
+		Short  string `positional-arg-name:"short" required:"yes" description:"short url, or the target url to generate a short url"`
+		Target string `positional-arg-name:"target" description:"target url"`
+	} `positional-args:"yes"`
+}
+
+// Execute adds a link to the file, validating it as if it were a csv
+// record. If only a target url is given, a random short url is made for
+// it.
+func (a *addCommand) Execute(args []string) error {
+	m, err := loadURLFile(a.Args.File)
+	if err != nil {
+		return err
+	}
+	l := Link{Short: a.Args.Short, Target: a.Args.Target, Status: a.Status}
+	if l.Target == "" {
+		g, err := newCodeGenerator(a.Alphabet, a.Length)
+		if err != nil {
+			return err
+		}
+		l.Target = l.Short
+		l.Short, err = g.generate(func(code string) (bool, error) {
+			_, exists := m[code]
+			return exists, nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	l, err = validateLink(l)
+	if err != nil {
+		return err
+	}
+	if _, exists := m[l.Short]; exists {
+		return fmt.Errorf("short url %s already exists in %s", l.Short, a.Args.File)
+	}
+	m[l.Short] = l
+	err = writeURLFile(a.Args.File, sortedLinks(m))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(output, "added %s\n", l.Short)
+	return nil
+}
+
+// removeCommand is the "remove" command
+type removeCommand struct {
+	Args struct {
+		File  string   `positional-arg-name:"file" required:"yes" description:"csv link file"`
+		Short []string `positional-arg-name:"short" required:"1" description:"short urls to remove"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+// Execute removes links from the file
+func (rm *removeCommand) Execute(args []string) error {
+	m, err := loadURLFile(rm.Args.File)
+	if err != nil {
+		return err
+	}
+	for _, su := range rm.Args.Short {
+		if _, exists := m[su]; !exists {
+			return fmt.Errorf("short url %s not found in %s", su, rm.Args.File)
+		}
+		delete(m, su)
+	}
+	err = writeURLFile(rm.Args.File, sortedLinks(m))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(output, "removed %s\n", strings.Join(rm.Args.Short, ", "))
+	return nil
+}
+
+// statsCommand is the "stats" command
+type statsCommand struct {
+	Args struct {
+		File string `positional-arg-name:"file" description:"csv link file (default: the embedded file)"`
+	} `positional-args:"yes"`
+}
+
+// Execute summarises the links in the file
+func (st *statsCommand) Execute(args []string) error {
+	m, err := loadURLFile(st.Args.File)
+	if err != nil {
+		return err
+	}
+	statuses := map[string]int{}
+	hosts := map[string]int{}
+	meta := map[string]int{}
+	for _, l := range m {
+		status := "default"
+		if l.Status != 0 {
+			status = strconv.Itoa(l.Status)
+		}
+		statuses[status]++
+		if u, err := url.Parse(l.Target); err == nil {
+			hosts[u.Host]++
+		}
+		for k := range l.Meta {
+			meta[k]++
+		}
+	}
+
+	tw := tabwriter.NewWriter(output, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "links\t%d\n", len(m))
+	fmt.Fprintf(tw, "hosts\t%d\n", len(hosts))
+	for _, kv := range byCount(statuses) {
+		fmt.Fprintf(tw, "status %s\t%d\n", kv.key, kv.count)
+	}
+	for _, kv := range byCount(meta) {
+		fmt.Fprintf(tw, "metadata %s\t%d\n", kv.key, kv.count)
+	}
+	for i, kv := range byCount(hosts) {
+		if i == 10 {
+			break
+		}
+		fmt.Fprintf(tw, "host %s\t%d\n", kv.key, kv.count)
+	}
+	return tw.Flush()
+}
+
+// keyCount is a key and its count
+type keyCount struct {
+	key   string
+	count int
+}
+
+// byCount returns the keys and counts in m by descending count then key
+func byCount(m map[string]int) []keyCount {
+	kc := make([]keyCount, 0, len(m))
+	for k, v := range m {
+		kc = append(kc, keyCount{k, v})
+	}
+	sort.Slice(kc, func(i, j int) bool {
+		if kc[i].count != kc[j].count {
+			return kc[i].count > kc[j].count
+		}
+		return kc[i].key < kc[j].key
+	})
+	return kc
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// commandOutput redirects output to a buffer for the duration of a test
+func commandOutput(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	output = &buf
+	t.Cleanup(func() {
+		output = os.Stdout
+	})
+	return &buf
+}
+
+// tmpLinkFile writes contents to a temporary csv file, returning its
+// path
+func tmpLinkFile(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "links.csv")
+	err := os.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readLinkFile returns the contents of a csv file
+func readLinkFile(t *testing.T, path string) string {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestAddRemoveCommands(t *testing.T) {
+	_ = commandOutput(t)
+	path := tmpLinkFile(t, "def,https://def\nabc,https://abc\n")
+
+	a := addCommand{Status: 302}
+	a.Args.File, a.Args.Short, a.Args.Target = path, " ghi/", " https://ghi"
+	if err := a.Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readLinkFile(t, path), "def,https://def,\nabc,https://abc,\nghi,https://ghi,302\n"; got != want {
+		t.Errorf("after add got\n%s\nwant\n%s", got, want)
+	}
+
+	// generated short url
+	buf := commandOutput(t)
+	a = addCommand{Length: 8, Alphabet: defaultCodeAlphabet}
+	a.Args.File, a.Args.Short = path, "https://generated"
+	if err := a.Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+	code := strings.TrimSpace(strings.TrimPrefix(buf.String(), "added "))
+	if len(code) != 8 || !strings.Contains(readLinkFile(t, path), code+",https://generated,\n") {
+		t.Errorf("generated link %s not added: %s", code, readLinkFile(t, path))
+	}
+	rm := removeCommand{}
+	rm.Args.File, rm.Args.Short = path, []string{code}
+	if err := rm.Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+	a = addCommand{Length: 1, Alphabet: defaultCodeAlphabet}
+	a.Args.File, a.Args.Short = path, "https://generated"
+	if err := a.Execute(nil); err == nil {
+		t.Error("expected error for short length")
+	}
+
+	// duplicate, invalid short url, invalid target and invalid status
+	for _, l := range []Link{
+		{Short: "abc", Target: "https://abc"},
+		{Short: "a#c", Target: "https://abc"},
+		{Short: "xyz", Target: "ftp://abc"},
+		{Short: "xyz", Target: "https://abc", Status: 200},
+	} {
+		a := addCommand{Status: l.Status}
+		a.Args.File, a.Args.Short, a.Args.Target = path, l.Short, l.Target
+		if err := a.Execute(nil); err == nil {
+			t.Errorf("expected error adding %v", l)
+		}
+	}
+
+	rm = removeCommand{}
+	rm.Args.File, rm.Args.Short = path, []string{"abc", "ghi"}
+	if err := rm.Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readLinkFile(t, path), "def,https://def\n"; got != want {
+		t.Errorf("after remove got\n%s\nwant\n%s", got, want)
+	}
+	if err := rm.Execute(nil); err == nil {
+		t.Error("expected error removing missing link")
+	}
+}
+
+func TestExportImportCommands(t *testing.T) {
+	buf := commandOutput(t)
+	src := tmpLinkFile(t, "short,target,owner\nabc,https://abc,rory\ndef,https://def,\n")
+
+	e := exportCommand{Format: "json"}
+	e.Args.File = src
+	if err := e.Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"owner": "rory"`) {
+		t.Errorf("json export missing metadata:\n%s", buf.String())
+	}
+	jsonPath := filepath.Join(t.TempDir(), "links.json")
+	if err := os.WriteFile(jsonPath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := tmpLinkFile(t, "abc,https://old\nxyz,https://xyz\n")
+	i := importCommand{Format: "json"}
+	i.Args.File, i.Args.Source = dst, jsonPath
+	if err := i.Execute(nil); err == nil {
+		t.Error("expected duplicate error without replace")
+	}
+	i.Replace = true
+	if err := i.Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+	want := "short,target,status,owner\nabc,https://abc,,rory\nxyz,https://xyz,,\ndef,https://def,,\n"
+	if got := readLinkFile(t, dst); got != want {
+		t.Errorf("after import got\n%s\nwant\n%s", got, want)
+	}
+
+	// csv export round trips
+	buf.Reset()
+	e = exportCommand{Format: "csv"}
+	e.Args.File = dst
+	if err := e.Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != want {
+		t.Errorf("csv export got\n%s\nwant\n%s", got, want)
+	}
+
+	// invalid json import
+	bad := filepath.Join(t.TempDir(), "bad.json")
+	if err := os.WriteFile(bad, []byte(`[{"short":"a b","target":"https://x"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	i.Args.Source = bad
+	if err := i.Execute(nil); err == nil {
+		t.Error("expected error importing invalid link")
+	}
+}
+
+func TestStatsCommand(t *testing.T) {
+	buf := commandOutput(t)
+	path := tmpLinkFile(t, "short,target,status,owner\nabc,https://a.com/x,302,rory\ndef,https://a.com/y,,\nghi,https://b.com,,\n")
+	st := statsCommand{}
+	st.Args.File = path
+	if err := st.Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"links  ", "3\n", "status default", "status 302", "metadata owner", "host a.com"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("stats output does not contain %q:\n%s", want, buf.String())
+		}
+	}
+
+	// the embedded file is used by default
+	buf.Reset()
+	if err := (&statsCommand{}).Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+}
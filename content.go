@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// content checks that the page served by a link target is of an
+// expected type, by default html. Optionally the start of the body is
+// sniffed to detect pages that still serve html but have been replaced
+// by a parked-domain page or a login wall.
+
+var ParkedDomainPage error = errors.New("parked domain page")
+var LoginWallPage error = errors.New("login wall page")
+
+// metaContentTypes is the link metadata column listing the content
+// types allowed for a link's target, separated by spaces or semicolons,
+// for example "application/pdf" or "text/html; application/pdf"
+const metaContentTypes = "content-types"
+
+// defaultContentTypes are the content types allowed for targets without
+// a content-types metadata entry
+var defaultContentTypes = []string{"text/html", "application/xhtml+xml"}
+
+// sniffBytes is the maximum amount of a body read when sniffing
+const sniffBytes = 64 << 10
+
+// parkedRegex matches phrases typical of parked or for-sale domain
+// pages
+var parkedRegex = regexp.MustCompile(`(?i)(this domain (name )?(is|may be) for sale|buy this domain|domain (is )?parked|parked free|sedoparking|parkingcrew|hugedomains\.com|domain parking)`)
+
+// passwordRegex matches a password input field
+var passwordRegex = regexp.MustCompile(`(?i)<input[^>]+type\s*=\s*["']?password`)
+
+// loginPathRegex matches paths typical of login pages
+var loginPathRegex = regexp.MustCompile(`(?i)/(log-?in|sign-?in|auth|sso|session/new)(/|$|\?)`)
+
+// linkContentTypes returns the content types allowed for a link from its
+// metadata, or nil if the default types apply
+func linkContentTypes(l Link) []string {
+	types := strings.FieldsFunc(l.Meta[metaContentTypes], func(r rune) bool {
+		return r == ';' || r == ' ' || r == '\t'
+	})
+	if len(types) == 0 {
+		return nil
+	}
+	return types
+}
+
+// contentTypeAllowed reports if the media type is matched by one of the
+// allowed types, which may use wildcards such as "text/*" or "*/*"
+func contentTypeAllowed(mediaType string, allowed []string) bool {
+	major, _, _ := strings.Cut(mediaType, "/")
+	for _, a := range allowed {
+		a = strings.ToLower(strings.TrimSpace(a))
+		switch {
+		case a == "*/*" || a == mediaType:
+			return true
+		case strings.HasSuffix(a, "/*") && strings.TrimSuffix(a, "/*") == major:
+			return true
+		}
+	}
+	return false
+}
+
+// inspect checks the content type of a successful response against the
+// allowed types and, if sniff is set, inspects the start of an html body
+// for parked-domain and login wall pages. The body is read but not
+// closed.
+func inspect(resp *http.Response, allowed []string, sniff bool) error {
+	if len(allowed) == 0 {
+		allowed = defaultContentTypes
+	}
+
+	var head []byte
+	if sniff {
+		var err error
+		head, err = io.ReadAll(io.LimitReader(resp.Body, sniffBytes))
+		if err != nil {
+			return fmt.Errorf("body read error: %w", err)
+		}
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" && sniff {
+		contentType = http.DetectContentType(head)
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return fmt.Errorf("%w: could not parse content type %q", NonHTMLPageType, contentType)
+	}
+	if !contentTypeAllowed(mediaType, allowed) {
+		return fmt.Errorf("%w: %s", NonHTMLPageType, mediaType)
+	}
+	if !sniff || !contentTypeAllowed(mediaType, defaultContentTypes) {
+		return nil
+	}
+
+	if m := parkedRegex.Find(head); m != nil {
+		return fmt.Errorf("%w: page contains %q", ParkedDomainPage, m)
+	}
+	if passwordRegex.Match(head) {
+		return fmt.Errorf("%w: page contains a password field", LoginWallPage)
+	}
+	if resp.Request != nil && loginPathRegex.MatchString(resp.Request.URL.Path) {
+		original := resp.Request
+		for original.Response != nil {
+			original = original.Response.Request
+		}
+		if !loginPathRegex.MatchString(original.URL.Path) {
+			return fmt.Errorf("%w: redirected to %s", LoginWallPage, resp.Request.URL.Path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLinkContentTypes(t *testing.T) {
+	tests := []struct {
+		meta  map[string]string
+		types []string
+	}{
+		{nil, nil},
+		{map[string]string{"owner": "x"}, nil},
+		{map[string]string{metaContentTypes: "application/pdf"}, []string{"application/pdf"}},
+		{map[string]string{metaContentTypes: " text/html; application/pdf "}, []string{"text/html", "application/pdf"}},
+	}
+	for i, tt := range tests {
+		got := linkContentTypes(Link{Meta: tt.meta})
+		if fmt.Sprint(got) != fmt.Sprint(tt.types) {
+			t.Errorf("test %d got %v want %v", i, got, tt.types)
+		}
+	}
+}
+
+func TestContentTypeAllowed(t *testing.T) {
+	tests := []struct {
+		mediaType string
+		allowed   []string
+		ok        bool
+	}{
+		{"text/html", defaultContentTypes, true},
+		{"application/xhtml+xml", defaultContentTypes, true},
+		{"application/pdf", defaultContentTypes, false},
+		{"application/pdf", []string{"application/pdf"}, true},
+		{"image/png", []string{"image/*"}, true},
+		{"text/plain", []string{"image/*"}, false},
+		{"text/plain", []string{"*/*"}, true},
+		{"text/html", []string{" Text/HTML "}, true},
+	}
+	for i, tt := range tests {
+		if got := contentTypeAllowed(tt.mediaType, tt.allowed); got != tt.ok {
+			t.Errorf("test %d %s in %v got %t want %t", i, tt.mediaType, tt.allowed, got, tt.ok)
+		}
+	}
+}
+
+// TestContentChecks checks content type enforcement and body sniffing
+// through the getClient
+func TestContentChecks(t *testing.T) {
+	mux := http.NewServeMux()
+	page := func(contentType, body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if contentType != "" {
+				w.Header().Set("Content-Type", contentType)
+			}
+			fmt.Fprint(w, body)
+		}
+	}
+	mux.HandleFunc("/html", page("text/html; charset=utf-8", "<html><title>ok</title></html>"))
+	mux.HandleFunc("/pdf", page("application/pdf", "%PDF-1.4"))
+	mux.HandleFunc("/json", page("application/json", `{"a": 1}`))
+	mux.HandleFunc("/sniffed", page("", "<!DOCTYPE html><html>sniffed</html>"))
+	mux.HandleFunc("/parked", page("text/html", "<html><h1>This domain is for sale!</h1></html>"))
+	mux.HandleFunc("/password", page("text/html", `<html><form><input name="p" type="password"></form></html>`))
+	mux.HandleFunc("/private", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/login?next=private", http.StatusFound)
+	})
+	mux.HandleFunc("/login", page("text/html", "<html>please log in</html>"))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	tests := []struct {
+		path  string
+		meta  map[string]string
+		sniff bool
+		err   error
+	}{
+		{"/html", nil, false, nil},
+		{"/html", nil, true, nil},
+		{"/pdf", nil, false, NonHTMLPageType},
+		{"/pdf", map[string]string{metaContentTypes: "application/pdf"}, true, nil},
+		{"/json", map[string]string{metaContentTypes: "application/pdf"}, false, NonHTMLPageType},
+		{"/sniffed", nil, true, nil},
+		{"/parked", nil, false, nil},
+		{"/parked", nil, true, ParkedDomainPage},
+		{"/password", nil, true, LoginWallPage},
+		{"/private", nil, true, LoginWallPage},
+		{"/login", nil, true, nil}, // login pages may be linked to directly
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			g := NewGetClient(1, time.Second)
+			g.sniff = tt.sniff
+			target := ts.URL + tt.path
+			g.allowContentTypes([]Link{{Short: "x", Target: target, Meta: tt.meta}})
+			r := g.fetch(context.Background(), target)
+			if tt.err == nil && r.Err != nil {
+				t.Errorf("unexpected error %v", r.Err)
+			}
+			if tt.err != nil && !errors.Is(r.Err, tt.err) {
+				t.Errorf("expected %v, got %v", tt.err, r.Err)
+			}
+			if tt.err != nil && r.OK() {
+				t.Error("result should not be ok")
+			}
+			if tt.err == ParkedDomainPage && !strings.Contains(r.Err.Error(), "for sale") {
+				t.Errorf("unexpected parked error %v", r.Err)
+			}
+		})
+	}
+}
+
+// TestAllowContentTypes checks that the types allowed for a target
+// shared by several links are merged
+func TestAllowContentTypes(t *testing.T) {
+	pdf := map[string]string{metaContentTypes: "application/pdf"}
+	tests := []struct {
+		links []Link
+		want  []string
+	}{
+		{
+			[]Link{{Short: "a", Target: "https://x"}},
+			[]string{"text/html", "application/xhtml+xml"},
+		},
+		{
+			[]Link{{Short: "a", Target: "https://x", Meta: pdf}},
+			[]string{"application/pdf"},
+		},
+		{
+			[]Link{
+				{Short: "a", Target: "https://x", Meta: pdf},
+				{Short: "b", Target: "https://x", Meta: map[string]string{metaContentTypes: "image/png; Application/PDF"}},
+			},
+			[]string{"application/pdf", "image/png"},
+		},
+		{
+			[]Link{
+				{Short: "a", Target: "https://x", Meta: pdf},
+				{Short: "b", Target: "https://x"},
+			},
+			[]string{"application/pdf", "text/html", "application/xhtml+xml"},
+		},
+		{
+			[]Link{
+				{Short: "a", Target: "https://x", Meta: pdf},
+				{Short: "b", Target: "https://y"},
+			},
+			[]string{"application/pdf"},
+		},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			g := NewGetClient(1, time.Second)
+			g.allowContentTypes(tt.links)
+			if got := g.contentTypes["https://x"]; !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// fallback redirects to a fallback url instead of a link's target once
+// background health checks have found the target failing for longer
+// than a set time. A link's fallback is given by its fallback metadata,
+// or made from a global pattern such as a web archive url template.
+
+// metaFallback is the link metadata column giving a link's fallback url
+const metaFallback = "fallback"
+
+// defaultFallbackAfter is how long a target must fail before its
+// fallback is used
+const defaultFallbackAfter = 24 * time.Hour
+
+// fallback pattern placeholders
+const (
+	fallbackTarget = "{target}" // the link's target url
+	fallbackShort  = "{short}"  // the short url
+)
+
+// fallbackURL returns the fallback url for a link from its metadata or,
+// if that is not set, from pattern, or an empty string if neither is
+// set. Placeholders in the pattern's query, after "?", are query
+// escaped, so that a target with its own query makes a single value.
+func fallbackURL(l Link, pattern string) string {
+	if fb := l.Meta[metaFallback]; fb != "" {
+		return fb
+	}
+	if pattern == "" {
+		return ""
+	}
+	path, query, hasQuery := strings.Cut(pattern, "?")
+	fb := strings.NewReplacer(fallbackTarget, l.Target, fallbackShort, l.Short).Replace(path)
+	if hasQuery {
+		fb += "?" + strings.NewReplacer(
+			fallbackTarget, url.QueryEscape(l.Target),
+			fallbackShort, url.QueryEscape(l.Short),
+		).Replace(query)
+	}
+	return fb
+}
+
+// checkFallback checks that a link's fallback url, if set, is an http
+// url
+func checkFallback(short, fb string) error {
+	fb = strings.TrimSpace(fb)
+	if fb != "" && strings.Index(fb, "http") != 0 {
+		return fmt.Errorf("short url %s fallback %s does not start with http", short, fb)
+	}
+	return nil
+}
+
+// validFallbackPattern checks that a fallback pattern makes an http or
+// https url
+func validFallbackPattern(pattern string) error {
+	example := fallbackURL(Link{Short: "short", Target: "https://example.com/page?id=1&lang=en"}, pattern)
+	u, err := url.Parse(example)
+	if err != nil {
+		return fmt.Errorf("invalid fallback pattern: %v", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("fallback pattern %s does not make an http url", pattern)
+	}
+	return nil
+}
+
+// failingTarget reports if a link's target has been failing health
+// checks for longer than the server's fallback time, with when it
+// started failing and why
+func (s *server) failingTarget(l Link) (time.Time, string, bool) {
+	if s.monitor == nil || s.fallbackAfter <= 0 {
+		return time.Time{}, "", false
+	}
+	since, reason, failing := s.monitor.failing(l.Short)
+	if !failing || time.Since(since) < s.fallbackAfter {
+		return since, reason, false
+	}
+	return since, reason, true
+}
+
+// pickTarget returns the url and status to redirect a link to,
+// reporting if the url is the link's fallback. If the link's target is
+// failing and the link has a fallback, the fallback is used with a
+// temporary redirect, so that the target is used again once it
+// recovers.
+func (s *server) pickTarget(l Link, failing bool) (string, int, bool) {
+	status := l.Status
+	if status == 0 {
+		status = s.defaultStatus
+	}
+	if failing {
+		if fb := fallbackURL(l, s.fallbackURLs); fb != "" {
+			return fb, http.StatusFound, true
+		}
+	}
+	return l.Target, status, false
+}
+
+// linkTarget returns where a link currently redirects to, as
+// pickTarget, without logging, for showing a link's destination
+func (s *server) linkTarget(l Link) (string, int, bool) {
+	_, _, failing := s.failingTarget(l)
+	return s.pickTarget(l, failing)
+}
+
+// redirectTarget returns where to redirect a link to, as pickTarget,
+// logging the use of a fallback for a failing target or the lack of one
+func (s *server) redirectTarget(l Link) (string, int, bool) {
+	since, reason, failing := s.failingTarget(l)
+	target, status, fallback := s.pickTarget(l, failing)
+	switch {
+	case fallback:
+		log.Printf("redirecting %s to fallback %s: target %s failing since %s: %s",
+			l.Short, target, l.Target, since.Format(time.RFC3339), reason)
+	case failing:
+		log.Printf("no fallback for %s: target %s failing since %s: %s",
+			l.Short, l.Target, since.Format(time.RFC3339), reason)
+	}
+	return target, status, fallback
+}
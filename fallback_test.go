@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFallbackURL(t *testing.T) {
+	withMeta := Link{Short: "abc", Target: "https://abc/page", Meta: map[string]string{metaFallback: "https://fallback/abc"}}
+	plain := Link{Short: "abc", Target: "https://abc/page"}
+	queried := Link{Short: "abc", Target: "https://abc/page?id=1&lang=en"}
+	tests := []struct {
+		link     Link
+		pattern  string
+		fallback string
+	}{
+		{withMeta, "", "https://fallback/abc"},
+		{withMeta, "https://web.archive.org/web/{target}", "https://fallback/abc"}, // metadata first
+		{plain, "https://web.archive.org/web/{target}", "https://web.archive.org/web/https://abc/page"},
+		{plain, "https://moved.example.com/{short}", "https://moved.example.com/abc"},
+		{plain, "https://archive.example.com/find?url={target}&id={short}", "https://archive.example.com/find?url=https%3A%2F%2Fabc%2Fpage&id=abc"},
+		{queried, "https://archive.example.com/find?url={target}", "https://archive.example.com/find?url=https%3A%2F%2Fabc%2Fpage%3Fid%3D1%26lang%3Den"},
+		{queried, "https://web.archive.org/web/{target}", "https://web.archive.org/web/https://abc/page?id=1&lang=en"},
+		{plain, "", ""},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			if got := fallbackURL(tt.link, tt.pattern); got != tt.fallback {
+				t.Errorf("got %s want %s", got, tt.fallback)
+			}
+		})
+	}
+}
+
+func TestValidFallbackPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		isErr   bool
+	}{
+		{"https://web.archive.org/web/{target}", false},
+		{"http://moved.example.com/{short}", false},
+		{"https://example.com/gone", false},
+		{"https://archive.example.com/find?url={target}", false},
+		{"https://{short}.example.com/?from={target}", false},
+		{"{target}", false}, // the target itself is an http url
+		{"web.archive.org/web/{target}", true},
+		{"ftp://archive/{short}", true},
+		{"https://", true},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			err := validFallbackPattern(tt.pattern)
+			if (err != nil) != tt.isErr {
+				t.Errorf("%s got error %v want error %t", tt.pattern, err, tt.isErr)
+			}
+		})
+	}
+}
+
+func TestRedirectFallback(t *testing.T) {
+	now := time.Now()
+	longAgo := now.Add(-48 * time.Hour)
+	recently := now.Add(-time.Minute)
+	failed := healthCheck{now, checkResult{Status: http.StatusNotFound}}
+	passed := healthCheck{now, checkResult{Status: http.StatusOK}}
+
+	links := testLinks(map[string]string{
+		"dead":   "https://dead",
+		"own":    "https://own",
+		"recent": "https://recent",
+		"alive":  "https://alive",
+	})
+	own := links["own"]
+	own.Meta = map[string]string{metaFallback: "https://own-fallback"}
+	links["own"] = own
+
+	ns, err := newServer(false, "", "", time.Second, 2,
+		withMonitor(time.Hour, 3, 1),
+		withFallback(24*time.Hour, "https://web.archive.org/web/{target}"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.store = newMemStore(links)
+	ns.monitor.health = map[string]linkHealth{
+		"dead":   {Short: "dead", Target: "https://dead", FailingSince: &longAgo, Checks: []healthCheck{failed}},
+		"own":    {Short: "own", Target: "https://own", FailingSince: &longAgo, Checks: []healthCheck{failed}},
+		"recent": {Short: "recent", Target: "https://recent", FailingSince: &recently, Checks: []healthCheck{failed}},
+		"alive":  {Short: "alive", Target: "https://alive", Checks: []healthCheck{passed, failed}},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{shortURL}", ns.redirector)
+
+	tests := []struct {
+		short    string
+		status   int
+		location string
+	}{
+		{"dead", http.StatusFound, "https://web.archive.org/web/https://dead"},
+		{"own", http.StatusFound, "https://own-fallback"},
+		{"recent", http.StatusMovedPermanently, "https://recent"},
+		{"alive", http.StatusMovedPermanently, "https://alive"},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest("GET", "/"+tt.short, nil))
+			if rec.Code != tt.status || rec.Header().Get("Location") != tt.location {
+				t.Errorf("%s got %d %s want %d %s",
+					tt.short, rec.Code, rec.Header().Get("Location"), tt.status, tt.location)
+			}
+		})
+	}
+
+	// resolving a target to show it reports the fallback without logging
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	for short, want := range map[string]bool{"own": true, "recent": false, "alive": false} {
+		if _, _, fallback := ns.linkTarget(links[short]); fallback != want {
+			t.Errorf("%s fallback got %t want %t", short, fallback, want)
+		}
+	}
+	if buf.Len() != 0 {
+		t.Errorf("unexpected log output %q", buf.String())
+	}
+	if _, _, fallback := ns.redirectTarget(links["own"]); !fallback || !strings.Contains(buf.String(), "to fallback") {
+		t.Errorf("redirect fallback %t with log output %q", fallback, buf.String())
+	}
+
+	// without a pattern, links without fallback metadata keep their target
+	ns.fallbackURLs = ""
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/dead", nil))
+	if got, want := rec.Header().Get("Location"), "https://dead"; got != want {
+		t.Errorf("location got %s want %s", got, want)
+	}
+}
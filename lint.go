@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// lint validates csv link files, reporting problems in a compiler-style
+// file:line: message format suitable for editors and pre-commit hooks.
+
+// lintCommand is the "lint" command
+type lintCommand struct {
+	NearDuplicates bool     `short:"n" long:"near-duplicates" description:"also report short urls differing only by case or hyphens"`
+	Normalise      []string `long:"normalise" choice:"case" choice:"dashes" choice:"punctuation" description:"report short urls which are the same under the server's --normalise policy"`
+	Args           struct {
+		Files []string `positional-arg-name:"file" required:"1"`
+	} `positional-args:"yes" required:"yes"`
+
+	norm normaliser
+}
+
+// Execute lints each file, returning an error if any problems are found
+func (l *lintCommand) Execute(args []string) error {
+	norm, err := newNormaliser(l.Normalise)
+	if err != nil {
+		return err
+	}
+	l.norm = norm
+	problems := 0
+	for _, file := range l.Args.Files {
+		problems += l.lint(file)
+	}
+	if problems > 0 {
+		return fmt.Errorf("lint found %d problem(s)", problems)
+	}
+	return nil
+}
+
+// lint reports the problems in a csv link file to output, returning the
+// number of problems found
+func (l *lintCommand) lint(file string) int {
+	f, err := os.Open(file)
+	if err != nil {
+		fmt.Fprintf(output, "%s: %v\n", file, err)
+		return 1
+	}
+	defer f.Close()
+
+	links, err := validateURLs(f)
+	var errs csvErrors
+	if err != nil && !errors.As(err, &errs) {
+		fmt.Fprintf(output, "%s: %v\n", file, err)
+		return 1
+	}
+	var collisions csvErrors
+	if errors.As(l.norm.check(links), &collisions) {
+		errs = append(errs, collisions...)
+		sort.SliceStable(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+	}
+	for _, e := range errs {
+		if e.Line == 0 {
+			fmt.Fprintf(output, "%s: %v\n", file, e.Err)
+			continue
+		}
+		fmt.Fprintf(output, "%s:%d: %v\n", file, e.Line, e.Err)
+	}
+	problems := len(errs)
+
+	if l.NearDuplicates {
+		for _, nd := range nearDuplicates(links, nearDuplicateKey) {
+			fmt.Fprintf(output, "%s:%d: short url %s is a near duplicate of %s at line %d\n",
+				file, nd.line, nd.Short, nd.of.Short, nd.of.line)
+			problems++
+		}
+	}
+	return problems
+}
+
+// nearDuplicate is a link whose short url differs from an earlier link
+// only by case or hyphens
+type nearDuplicate struct {
+	Link
+	of Link
+}
+
+// nearDuplicateKey folds a short url to lowercase and removes hyphens
+func nearDuplicateKey(su string) string {
+	return strings.ReplaceAll(strings.ToLower(su), "-", "")
+}
+
+// nearDuplicates returns the near duplicate links in line order, each
+// recorded against the first link with the same short url once folded
+// by key
+func nearDuplicates(links map[string]Link, key func(string) string) []nearDuplicate {
+	ordered := make([]Link, 0, len(links))
+	for _, l := range links {
+		ordered = append(ordered, l)
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if ordered[i].line != ordered[j].line {
+			return ordered[i].line < ordered[j].line
+		}
+		return ordered[i].Short < ordered[j].Short
+	})
+
+	seen := map[string]Link{}
+	dups := []nearDuplicate{}
+	for _, l := range ordered {
+		k := key(l.Short)
+		if first, ok := seen[k]; ok {
+			dups = append(dups, nearDuplicate{Link: l, of: first})
+			continue
+		}
+		seen[k] = l
+	}
+	return dups
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	var buf bytes.Buffer
+	output = &buf
+	defer func() {
+		output = os.Stdout
+	}()
+
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.csv")
+	bad := filepath.Join(dir, "bad.csv")
+	err := os.WriteFile(good, []byte("abc,https://abc\nA-bc,https://abc\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	norm := filepath.Join(dir, "norm.csv")
+	err = os.WriteFile(norm, []byte("abc,https://abc\ndef,https://def\nDEF,https://def\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(bad, []byte("abc,https://abc\nd ef,https://def\nghi,ghi\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		files          []string
+		nearDuplicates bool
+		normalise      []string
+		isErr          bool
+		output         []string
+	}{
+		{"good", []string{good}, false, nil, false, nil},
+		{"near duplicates", []string{good}, true, nil, true, []string{
+			good + ":2: short url A-bc is a near duplicate of abc at line 1",
+		}},
+		{"normalised", []string{good}, false, []string{"case"}, false, nil},
+		{"normalised collision", []string{norm}, false, []string{"case"}, true, []string{
+			norm + ":3: short url DEF is the same as def at line 2 once normalised",
+		}},
+		{"bad", []string{bad}, false, nil, true, []string{
+			bad + ":2: short url d ef has a space",
+			bad + ":3: target ghi does not start with http",
+		}},
+		{"missing", []string{filepath.Join(dir, "missing.csv")}, false, nil, true, []string{
+			"missing.csv: open",
+		}},
+		{"data", []string{"data/short-urls.csv"}, true, []string{"case", "dashes", "punctuation"}, false, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			l := lintCommand{NearDuplicates: tt.nearDuplicates, Normalise: tt.normalise}
+			l.Args.Files = tt.files
+			err := l.Execute(nil)
+			if err == nil && tt.isErr {
+				t.Errorf("expected error, output:\n%s", buf.String())
+			}
+			if err != nil && !tt.isErr {
+				t.Errorf("unexpected error %v, output:\n%s", err, buf.String())
+			}
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			if len(tt.output) == 0 && buf.Len() > 0 {
+				t.Errorf("unexpected output %s", buf.String())
+			}
+			for i, want := range tt.output {
+				if i >= len(lines) || !strings.Contains(lines[i], want) {
+					t.Errorf("output line %d does not contain %q:\n%s", i, want, buf.String())
+				}
+			}
+		})
+	}
+}
+
+func TestNearDuplicates(t *testing.T) {
+	links, err := urls(strings.NewReader("Abc,https://a\nab-c,https://b\nxyz,https://c\nABC,https://d\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dups := nearDuplicates(links, nearDuplicateKey)
+	if got, want := len(dups), 2; got != want {
+		t.Fatalf("got %d near duplicates want %d", got, want)
+	}
+	for i, want := range []string{"ab-c", "ABC"} {
+		if dups[i].Short != want || dups[i].of.Short != "Abc" {
+			t.Errorf("near duplicate %d got %s of %s", i, dups[i].Short, dups[i].of.Short)
+		}
+	}
+}
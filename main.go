@@ -1,24 +1,61 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jessevdk/go-flags"
 )
 
-// Options are the command line options
+// Options are the command line options, being the commands. Serving is
+// the default if no command is given.
 type Options struct {
+	Serve  serveCommand  `command:"serve" description:"run the web server (the default)"`
+	Check  checkCommand  `command:"check" description:"check the target of each link" long-description:"Check the target of each link, reporting the status, latency, final url and any error as a table, json or JUnit XML. Redirect chains to a different domain, to the site root or longer than --max-hops are reported as failures; use --format redirects for a csv of redirected links and their final urls. Exits with a non-zero status if any check fails."`
+	Lint   lintCommand   `command:"lint" description:"validate csv link files" long-description:"Validate csv link files, reporting every problem in file:line: message format. Exits with a non-zero status if any problems are found."`
+	Import importCommand `command:"import" description:"import links into a csv link file"`
+	Export exportCommand `command:"export" description:"export links as csv or json"`
+	Add    addCommand    `command:"add" description:"add a link to a csv link file"`
+	Remove removeCommand `command:"remove" description:"remove links from a csv link file"`
+	Stats  statsCommand  `command:"stats" description:"summarise the links in a csv link file"`
+	Token  tokenCommand  `command:"token" description:"make an api token" long-description:"Make a random api token, printing the token and the line to add to a tokens file or the URL_SHORTENER_TOKENS environment variable. Only the hash of the token is kept by the server."`
+
+	command flags.Commander // the command to run
+	args    []string        // arguments remaining for command
+}
+
+// serveCommand is the "serve" command, which runs the web server
+type serveCommand struct {
 	IPAddress   string        `short:"i" long:"ipaddress" default:"0.0.0.0" description:"ipaddress"`
 	Port        string        `short:"p" long:"port" default:"8000" description:"port"`
 	Development bool          `short:"d" long:"development" description:"run in development mode"`
-	Timeout     time.Duration `short:"t" long:"timeout" default:"5s" description:"development url checker timeout"`
+	Timeout     time.Duration `short:"t" long:"timeout" default:"5s" description:"url checker timeout"`
 	Workers     uint          `short:"w" long:"workers" default:"8" description:"development url checker workers"`
+	DataFile    string        `short:"f" long:"data-file" description:"csv file of links to use instead of the embedded file, reloaded on change or SIGHUP"`
+	DataPoll    time.Duration `long:"data-poll" default:"2s" description:"data file change polling interval"`
+	Status      int           `short:"s" long:"status" default:"301" description:"default redirect status (301, 302, 307 or 308)"`
+	Monitor     time.Duration `long:"monitor" description:"check the target of each link at this interval while serving, e.g. 6h"`
+	History     uint          `long:"monitor-history" default:"10" description:"link check results kept per link"`
+	MonWorkers  uint          `long:"monitor-workers" default:"2" description:"link check workers"`
+	FallAfter   time.Duration `long:"fallback-after" default:"24h" description:"redirect to a link's fallback once its target has failed checks for this long"`
+	FallPattern string        `long:"fallback-pattern" description:"fallback url for links without one, with {target} and {short} placeholders"`
+	API         bool          `long:"api" description:"serve the json api for managing links at /api/v1/links"`
+	Admin       bool          `long:"admin" description:"serve the admin console for managing links at /admin"`
+	CodeLength  int           `long:"code-length" description:"length of short urls generated for links created without one, instead of the default"`
+	CodeChars   string        `long:"code-alphabet" description:"characters of generated short urls, instead of the default"`
+	Normalise   []string      `long:"normalise" choice:"case" choice:"dashes" choice:"punctuation" description:"match short urls ignoring case, \"_\" for \"-\" or trailing punctuation; may be repeated"`
+	Suggest     int           `long:"suggestions" default:"3" description:"\"did you mean\" suggestions shown for short urls which are not found"`
+	SuggestAt   float64       `long:"suggest-redirect" description:"redirect to the closest suggestion if its confidence is at least this, from 0 to 1, e.g. 0.8; off if 0"`
+	TokensFile  string        `long:"tokens-file" description:"file of api tokens, one \"name scopes sha256-hash\" per line"`
+	Tokens      string        `long:"tokens" env:"URL_SHORTENER_TOKENS" description:"api tokens, in tokens file format separated by semicolons"`
 }
 
 var earlyExitError error = errors.New("early exit error")
@@ -26,63 +63,195 @@ var earlyExitError error = errors.New("early exit error")
 // output sets the io.Writer for output
 var output io.Writer = os.Stdout
 
-var usage string = `
+var usage string = `[OPTIONS]`
+
+var description string = `A web server for redirecting short urls.
 
-A web server for redirecting short urls.
+This uses a simple csv file of short,long urls as a database. An optional
+third column sets the redirect status for a link (301, 302, 307 or 308),
+otherwise the serve -s/--status default is used.
 
-This uses a simple csv file of short,long urls as a database.
+The serve command is the default, so its options may be given without
+it. Other commands work on csv link files without starting the server;
+use lint to validate a file, for example in a pre-commit hook, and add,
+remove, import and export to edit one. Use <command> -h for help on each
+command.`
+
+var serveDescription string = `Run the web server.
 
 Run with the -d/-development flag to run in development mode, providing
 live template reloads. In development mode, the urls are also checked at
-startup.`
+startup.
+
+Use -f/--data-file to serve links from a csv file outside the binary. The
+file is reloaded when it changes or when the server receives a SIGHUP;
+if the new file is invalid the previous links continue to be served.
+
+Use --monitor to check the target of each link on a schedule while
+serving. The most recent results for each link are shown on the home
+page and as json at /health. Links whose targets have been failing for
+longer than --fallback-after are redirected to the url in their fallback
+metadata column or, if they have none, to the --fallback-pattern url, for
+example https://web.archive.org/web/{target}.`
+
+// serveByDefault reports if args name no command, so that the serve
+// command is meant
+func serveByDefault(p *flags.Parser, args []string) bool {
+	if len(args) == 0 {
+		return true
+	}
+	switch args[0] {
+	case "-h", "--help":
+		return false
+	}
+	return p.Find(args[0]) == nil
+}
 
 // getFlags parses flags
 func getOptions() (Options, error) {
 	var options Options
 	var parser = flags.NewParser(&options, flags.Default)
 	parser.Usage = usage
+	parser.LongDescription = description
+	parser.Find("serve").LongDescription = serveDescription
+	parser.CommandHandler = func(command flags.Commander, args []string) error {
+		options.command, options.args = command, args
+		return nil
+	}
 
-	if _, err := parser.Parse(); err != nil {
+	args := os.Args[1:]
+	if serveByDefault(parser, args) {
+		args = append([]string{"serve"}, args...)
+	}
+	if _, err := parser.ParseArgs(args); err != nil {
 		if !flags.WroteHelp(err) {
 			parser.WriteHelp(output)
 		}
 		return options, earlyExitError
 	}
-	if net.ParseIP(options.IPAddress) == nil {
-		return options, errors.New("invalid ip address")
+	if _, serving := options.command.(*serveCommand); serving {
+		if len(options.args) > 0 {
+			return options, fmt.Errorf("unexpected arguments %s", strings.Join(options.args, " "))
+		}
+		if err := options.Serve.validate(); err != nil {
+			return options, err
+		}
 	}
-	if _, err := strconv.Atoi(options.Port); err != nil {
-		return options, errors.New("invalid network port")
+	return options, nil
+}
+
+// validate checks the serve command's options
+func (c *serveCommand) validate() error {
+	if net.ParseIP(c.IPAddress) == nil {
+		return errors.New("invalid ip address")
 	}
-	if options.Timeout < (time.Second * 2) {
-		return options, errors.New("timeout shorter than 2 seconds")
+	if _, err := strconv.Atoi(c.Port); err != nil {
+		return errors.New("invalid network port")
 	}
-	if options.Workers < 2 {
-		return options, errors.New("at least one worker is needed")
+	if c.Timeout < (time.Second * 2) {
+		return errors.New("timeout shorter than 2 seconds")
 	}
-	return options, nil
+	if c.Workers < 2 {
+		return errors.New("at least one worker is needed")
+	}
+	if c.DataFile != "" {
+		if _, err := os.Stat(c.DataFile); err != nil {
+			return fmt.Errorf("data file error: %w", err)
+		}
+	}
+	if c.DataPoll < (time.Millisecond * 100) {
+		return errors.New("data poll interval shorter than 100 milliseconds")
+	}
+	if c.Monitor != 0 && c.Monitor < time.Minute {
+		return errors.New("monitor interval shorter than 1 minute")
+	}
+	if c.History < 1 {
+		return errors.New("at least one monitor result must be kept")
+	}
+	if c.MonWorkers < 1 {
+		return errors.New("at least one monitor worker is needed")
+	}
+	if c.FallPattern != "" {
+		if err := validFallbackPattern(c.FallPattern); err != nil {
+			return err
+		}
+	}
+	if _, err := newCodeGenerator(c.CodeChars, c.CodeLength); err != nil {
+		return err
+	}
+	if c.Suggest < 0 {
+		return errors.New("suggestions cannot be negative")
+	}
+	if c.SuggestAt < 0 || c.SuggestAt > 1 {
+		return errors.New("suggestion redirect confidence is not between 0 and 1")
+	}
+	if !validStatus(c.Status) {
+		return errors.New("default status is not one of 301, 302, 307 or 308")
+	}
+	return nil
 }
 
-func main() {
-	options, err := getOptions()
+// Execute runs the web server until it fails or is interrupted. The
+// options are checked by getOptions.
+func (c *serveCommand) Execute(args []string) error {
+	tokens, err := loadTokens(c.TokensFile, c.Tokens)
 	if err != nil {
-		os.Exit(1)
+		return err
+	}
+	norm, err := newNormaliser(c.Normalise)
+	if err != nil {
+		return err
+	}
+	// stop serving, or checking links in development, on interrupt
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	opts := []serverOption{
+		withDataFile(c.DataFile, c.DataPoll),
+		withDefaultStatus(c.Status),
+		withMonitor(c.Monitor, int(c.History), int(c.MonWorkers)),
+		withFallback(c.FallAfter, c.FallPattern),
+		withTokens(tokens),
+		withCodes(c.CodeChars, c.CodeLength),
+		withNormalise(norm),
+		withSuggestions(c.Suggest, c.SuggestAt),
+		withContext(ctx),
+	}
+	if c.API {
+		opts = append(opts, withAPI())
+	}
+	if c.Admin {
+		opts = append(opts, withAdmin())
 	}
 	s, err := newServer(
-		options.Development,
-		options.IPAddress,
-		options.Port,
-		options.Timeout,
-		int(options.Workers),
+		c.Development,
+		c.IPAddress,
+		c.Port,
+		c.Timeout,
+		int(c.Workers),
+		opts...,
 	)
+	if err != nil {
+		return fmt.Errorf("server setup error %v", err)
+	}
+	err = s.serve()
+	if err != nil {
+		return fmt.Errorf("server error %v", err)
+	}
+	return nil
+}
 
+func main() {
+	options, err := getOptions()
 	if err != nil {
-		fmt.Printf("server setup error %v", err)
+		if !errors.Is(err, earlyExitError) {
+			fmt.Fprintln(os.Stderr, err)
+		}
 		os.Exit(1)
 	}
-	err = s.serve()
+	err = options.command.Execute(options.args)
 	if err != nil {
-		fmt.Printf("server error %v", err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
@@ -32,6 +32,8 @@ func TestGetOptions(t *testing.T) {
 		development bool
 		timeout     time.Duration
 		workers     uint
+		status      int
+		command     bool
 		ok          bool
 	}{
 		{ // 0
@@ -84,6 +86,126 @@ func TestGetOptions(t *testing.T) {
 			argString: "<prog> -w 0",
 			ok:        false,
 		},
+		{ // 10
+			argString: "<prog> -f data/short-urls.csv",
+			ok:        true,
+		},
+		{ // 11
+			argString: "<prog> -f data/nonexisting.csv",
+			ok:        false,
+		},
+		{ // 12
+			argString: "<prog> --data-poll 1ms",
+			ok:        false,
+		},
+		{ // 13
+			argString: "<prog> -s 307",
+			status:    307,
+			ok:        true,
+		},
+		{ // 14
+			argString: "<prog> -s 200",
+			ok:        false,
+		},
+		{ // 15
+			argString: "<prog> lint -n data/short-urls.csv",
+			command:   true,
+			ok:        true,
+		},
+		{ // 16
+			argString: "<prog> lint",
+			ok:        false, // no file
+		},
+		{ // 17
+			argString:   "<prog> serve -d -p 2000",
+			development: true,
+			port:        "2000",
+			ok:          true,
+		},
+		{ // 18
+			argString: "<prog> add data/short-urls.csv https://abc",
+			command:   true,
+			ok:        true, // the short url is generated
+		},
+		{ // 19
+			argString: "<prog> export --format json",
+			command:   true,
+			ok:        true,
+		},
+		{ // 20
+			argString: "<prog> --monitor 6h --monitor-history 5",
+			ok:        true,
+		},
+		{ // 21
+			argString: "<prog> --monitor 10s",
+			ok:        false, // too frequent
+		},
+		{ // 22
+			argString: "<prog> --monitor 1h --monitor-workers 0",
+			ok:        false,
+		},
+		{ // 23
+			argString: "<prog> token -n ci -s read -s link-write",
+			command:   true,
+			ok:        true,
+		},
+		{ // 24
+			argString: "<prog> token -s read",
+			ok:        false, // no name
+		},
+		{ // 25
+			argString: "<prog> --code-length 8 --code-alphabet abcdefgh",
+			ok:        true,
+		},
+		{ // 26
+			argString: "<prog> --code-length 2",
+			ok:        false, // too short
+		},
+		{ // 27
+			argString: "<prog> --code-alphabet ab_d",
+			ok:        false, // not valid in a short url
+		},
+		{ // 28
+			argString: "<prog> --normalise case --normalise dashes",
+			ok:        true,
+		},
+		{ // 29
+			argString: "<prog> --normalise spaces",
+			ok:        false,
+		},
+		{ // 30
+			argString: "<prog> --suggestions 5 --suggest-redirect 0.8",
+			ok:        true,
+		},
+		{ // 31
+			argString: "<prog> --suggest-redirect 80",
+			ok:        false, // not a fraction
+		},
+		{ // 32
+			argString: "<prog>",
+			ok:        true, // serve by default
+		},
+		{ // 33
+			argString: "<prog> lint -t 1s data/short-urls.csv",
+			ok:        false, // not a lint option
+		},
+		{ // 34
+			argString: "<prog> lint -f data/nonexisting.csv data/short-urls.csv",
+			ok:        false, // not a lint option
+		},
+		{ // 35
+			argString: "<prog> -d lint data/short-urls.csv",
+			ok:        false, // serve options before a command
+		},
+		{ // 36
+			argString: "<prog> token -n ci -s admin",
+			command:   true,
+			ok:        true, // -s is the token scope
+		},
+		{ // 37
+			argString: "<prog> serve -s 200",
+			ok:        false,
+		},
 	}
 	for i, tt := range tests {
 		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
@@ -94,21 +216,35 @@ func TestGetOptions(t *testing.T) {
 				t.Errorf("unexpected error %v", err)
 				return
 			}
+			if err == nil && !tt.ok {
+				t.Errorf("expected an error")
+				return
+			}
+			if err != nil {
+				return
+			}
+			serve := options.Serve
 
-			if tt.ip != "" && tt.ip != options.IPAddress {
-				t.Errorf("ip %s expected %s", options.IPAddress, tt.ip)
+			if tt.ip != "" && tt.ip != serve.IPAddress {
+				t.Errorf("ip %s expected %s", serve.IPAddress, tt.ip)
+			}
+			if tt.port != "" && tt.port != serve.Port {
+				t.Errorf("port %s expected %s", serve.Port, tt.port)
+			}
+			if tt.development != serve.Development {
+				t.Errorf("development %t unexpected (%t)", serve.Development, tt.development)
 			}
-			if tt.port != "" && tt.port != options.Port {
-				t.Errorf("port %s expected %s", options.Port, tt.port)
+			if tt.timeout != 0 && tt.timeout != serve.Timeout {
+				t.Errorf("timeout %v expected %v", serve.Timeout, tt.timeout)
 			}
-			if tt.development != options.Development {
-				t.Errorf("development %t unexpected (%t)", options.Development, tt.development)
+			if tt.workers != 0 && tt.workers != serve.Workers {
+				t.Errorf("workers %v expected %v", serve.Workers, tt.workers)
 			}
-			if tt.timeout != 0 && tt.timeout != options.Timeout {
-				t.Errorf("timeout %v expected %v", options.Timeout, tt.timeout)
+			if _, serving := options.command.(*serveCommand); tt.command == serving {
+				t.Errorf("command %v unexpected (%t)", options.command, tt.command)
 			}
-			if tt.workers != 0 && tt.workers != options.Workers {
-				t.Errorf("workers %v expected %v", options.Workers, tt.workers)
+			if tt.status != 0 && tt.status != serve.Status {
+				t.Errorf("status %v expected %v", serve.Status, tt.status)
 			}
 			if showBuf {
 				fmt.Println(buf.String())
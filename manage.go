@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// manage makes the changes to links shared by the api and the admin
+// console, so that links are validated and checked for duplicates in
+// the same way however they are changed.
+
+var linkExistsError error = errors.New("link already exists")
+var invalidLinkError error = errors.New("invalid link")
+
+// metaDisabled is the link metadata column which, if true, disables a
+// link so that it is not found
+const metaDisabled = "disabled"
+
+// linkDisabled reports if a link is disabled
+func linkDisabled(l Link) bool {
+	return metaFlag(l, metaDisabled)
+}
+
+// metaFlag reports if a link's metadata column is true, yes or 1
+func metaFlag(l Link, name string) bool {
+	switch strings.ToLower(strings.TrimSpace(l.Meta[name])) {
+	case "true", "yes", "1":
+		return true
+	}
+	return false
+}
+
+// createLink validates and stores a new link, returning an
+// invalidLinkError if it is invalid or linkExistsError if the short url
+// is taken. A short url is generated if the link has none. `by` names
+// who made the change, for the log.
+func (s *server) createLink(ctx context.Context, l Link, by string) (Link, error) {
+	s.linksMu.Lock()
+	defer s.linksMu.Unlock()
+	if strings.TrimSpace(l.Short) == "" {
+		var err error
+		l.Short, err = s.codes.generate(func(code string) (bool, error) {
+			return s.linkExists(ctx, code)
+		})
+		if err != nil {
+			return l, err
+		}
+	}
+	l, err := validateLink(l)
+	if err != nil {
+		return l, fmt.Errorf("%w: %w", invalidLinkError, err)
+	}
+	exists, err := s.linkExists(ctx, l.Short)
+	if err != nil {
+		return l, err
+	}
+	if exists {
+		return l, fmt.Errorf("short url %s: %w", l.Short, linkExistsError)
+	}
+	if err := s.store.Put(ctx, l); err != nil {
+		return l, err
+	}
+	log.Printf("created %s -> %s by %s", l.Short, l.Target, by)
+	return l, nil
+}
+
+// linkExists reports if a short url is in the store
+func (s *server) linkExists(ctx context.Context, shortURL string) (bool, error) {
+	_, err := s.store.Lookup(ctx, shortURL)
+	switch {
+	case err == nil:
+		return true, nil
+	case errors.Is(err, linkNotFoundError):
+		return false, nil
+	}
+	return false, err
+}
+
+// updateLink validates and replaces an existing link, returning an
+// invalidLinkError if it is invalid or linkNotFoundError if it does not
+// exist. A short url matching the link once normalised updates it.
+func (s *server) updateLink(ctx context.Context, l Link, by string) (Link, error) {
+	l, err := validateLink(l)
+	if err != nil {
+		return l, fmt.Errorf("%w: %w", invalidLinkError, err)
+	}
+	s.linksMu.Lock()
+	defer s.linksMu.Unlock()
+	existing, err := s.store.Lookup(ctx, l.Short)
+	if err != nil {
+		return l, err
+	}
+	l.Short = existing.Short
+	if err := s.store.Put(ctx, l); err != nil {
+		return l, err
+	}
+	log.Printf("updated %s -> %s by %s", l.Short, l.Target, by)
+	return l, nil
+}
+
+// deleteLink deletes a link, found in the same way as by a redirect,
+// returning linkNotFoundError if it does not exist
+func (s *server) deleteLink(ctx context.Context, shortURL, by string) error {
+	s.linksMu.Lock()
+	defer s.linksMu.Unlock()
+	existing, err := s.store.Lookup(ctx, shortURL)
+	if err != nil {
+		return err
+	}
+	if err := s.store.Delete(ctx, existing.Short); err != nil {
+		return err
+	}
+	log.Printf("deleted %s by %s", existing.Short, by)
+	return nil
+}
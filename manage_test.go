@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestLinkDisabled(t *testing.T) {
+	tests := []struct {
+		value    string
+		disabled bool
+	}{
+		{"", false},
+		{"true", true},
+		{"Yes", true},
+		{"1", true},
+		{"false", false},
+		{"no", false},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			l := Link{Short: "abc", Meta: map[string]string{metaDisabled: tt.value}}
+			if got := linkDisabled(l); got != tt.disabled {
+				t.Errorf("%q got %t want %t", tt.value, got, tt.disabled)
+			}
+		})
+	}
+}
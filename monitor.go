@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// monitor re-checks the target of every link on a schedule while the
+// server runs, keeping the most recent results for each link in memory
+// so that link rot shows up on the home page and the health endpoint
+// before readers find it. Checks use a small worker pool with one
+// request at a time to each host.
+
+// monitor defaults
+const defaultMonitorHistory = 10
+const defaultMonitorWorkers = 2
+
+// healthCheck is the result of a scheduled check of a link's target
+type healthCheck struct {
+	Checked time.Time
+	checkResult
+}
+
+// MarshalJSON encodes the check in the same form as the check
+// command's json report, with the time of the check
+func (h healthCheck) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Checked   time.Time `json:"checked"`
+		OK        bool      `json:"ok"`
+		Status    int       `json:"status"`
+		LatencyMS float64   `json:"latency_ms"`
+		FinalURL  string    `json:"final_url,omitempty"`
+		Attempts  int       `json:"attempts,omitempty"`
+		ErrType   string    `json:"error_type,omitempty"`
+		Error     string    `json:"error,omitempty"`
+	}{
+		Checked:   h.Checked,
+		OK:        h.OK(),
+		Status:    h.Status,
+		LatencyMS: float64(h.Duration.Microseconds()) / 1000,
+		FinalURL:  h.FinalURL,
+		Attempts:  h.Attempts,
+		ErrType:   h.ErrType(),
+		Error:     linkCheck{checkResult: h.checkResult}.errorMessage(),
+	})
+}
+
+// linkHealth is the check history of a link, most recent first.
+// FailingSince is the time of the first of the link's current run of
+// failed checks, which may be older than the checks kept.
+type linkHealth struct {
+	Short        string        `json:"short"`
+	Target       string        `json:"target"`
+	FailingSince *time.Time    `json:"failing_since,omitempty"`
+	Checks       []healthCheck `json:"checks"`
+}
+
+// OK reports if the most recent check of the link succeeded, or true if
+// it has not been checked
+func (lh linkHealth) OK() bool {
+	return len(lh.Checks) == 0 || lh.Checks[0].OK()
+}
+
+// healthReport summarises the link check history
+type healthReport struct {
+	Interval time.Duration `json:"-"`
+	LastRun  time.Time     `json:"last_run"`
+	Links    int           `json:"links"`
+	Failing  int           `json:"failing"`
+	Health   []linkHealth  `json:"health"`
+}
+
+// monitor periodically checks link targets
+type monitor struct {
+	store    Store
+	client   *getClient
+	interval time.Duration
+	history  int // results kept per link
+	mu       sync.RWMutex
+	lastRun  time.Time
+	health   map[string]linkHealth // by short url
+}
+
+// newMonitor makes a monitor checking the links in store every interval
+// with the given number of workers and http timeout, keeping history
+// results for each link
+func newMonitor(store Store, interval time.Duration, history, workers int, timeout time.Duration) *monitor {
+	if history < 1 {
+		history = defaultMonitorHistory
+	}
+	if workers < 1 {
+		workers = defaultMonitorWorkers
+	}
+	g := NewGetClient(workers, timeout)
+	g.retry = retryPolicy{retries: 1, backoff: time.Second, maxBackoff: maxRetryAfter}
+	g.hosts = newHostLimiter(1, 0)
+	return &monitor{
+		store:    store,
+		client:   g,
+		interval: interval,
+		history:  history,
+		health:   map[string]linkHealth{},
+	}
+}
+
+// run checks the target of each enabled link once, recording the
+// results. Links no longer in the store, or disabled, are forgotten.
+func (m *monitor) run(ctx context.Context) error {
+	all, err := m.store.List(ctx)
+	if err != nil {
+		return err
+	}
+	links := make([]Link, 0, len(all))
+	for _, l := range all {
+		if !linkDisabled(l) {
+			links = append(links, l)
+		}
+	}
+	targets := []string{}
+	byTarget := map[string][]Link{}
+	for _, l := range links {
+		if _, ok := byTarget[l.Target]; !ok {
+			targets = append(targets, l.Target)
+		}
+		byTarget[l.Target] = append(byTarget[l.Target], l)
+	}
+
+	// the client's content types are only read while checking
+	m.client.contentTypes = nil
+	m.client.allowContentTypes(links)
+
+	checked := time.Now()
+	results := map[string]checkResult{}
+	for r := range m.client.Stream(ctx, targets) {
+		results[r.URL] = r
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	health := make(map[string]linkHealth, len(links))
+	for target, ls := range byTarget {
+		r, ok := results[target]
+		for _, l := range ls {
+			lh := m.health[l.Short]
+			if lh.Target != l.Target {
+				lh = linkHealth{Short: l.Short, Target: l.Target}
+			}
+			if ok {
+				checks := append([]healthCheck{{checked, r}}, lh.Checks...)
+				lh.Checks = checks[:min(len(checks), m.history)]
+				switch {
+				case r.OK():
+					lh.FailingSince = nil
+				case lh.FailingSince == nil:
+					lh.FailingSince = &checked
+				}
+			}
+			health[l.Short] = lh
+		}
+	}
+	m.health = health
+	m.lastRun = checked
+	return nil
+}
+
+// watch runs checks immediately and then every interval until the
+// context is cancelled
+func (m *monitor) watch(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		start := time.Now()
+		if err := m.run(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("link monitor error: %v", err)
+		} else {
+			report := m.report()
+			log.Printf("link monitor checked %d links in %v, %d failing",
+				report.Links, time.Since(start).Round(time.Millisecond), report.Failing)
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// report returns the check history of each link, failing links first,
+// then by short url
+func (m *monitor) report() healthReport {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	r := healthReport{Interval: m.interval, LastRun: m.lastRun, Links: len(m.health)}
+	for _, lh := range m.health {
+		lh.Checks = append([]healthCheck(nil), lh.Checks...)
+		r.Health = append(r.Health, lh)
+		if !lh.OK() {
+			r.Failing++
+		}
+	}
+	sort.Slice(r.Health, func(i, j int) bool {
+		a, b := r.Health[i], r.Health[j]
+		if a.OK() != b.OK() {
+			return !a.OK()
+		}
+		return a.Short < b.Short
+	})
+	return r
+}
+
+// failing reports if the most recent check of a link failed, returning
+// when the link started failing and the error of the most recent check
+func (m *monitor) failing(short string) (since time.Time, reason string, ok bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	lh, exists := m.health[short]
+	if !exists || lh.OK() {
+		return time.Time{}, "", false
+	}
+	last := lh.Checks[0]
+	return *lh.FailingSince, linkCheck{checkResult: last.checkResult}.errorMessage(), true
+}
+
+// lastCheck returns the most recent check of a link, if any
+func (m *monitor) lastCheck(short string) (healthCheck, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	lh, ok := m.health[short]
+	if !ok || len(lh.Checks) == 0 {
+		return healthCheck{}, false
+	}
+	return lh.Checks[0], true
+}
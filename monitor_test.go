@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMonitorRun(t *testing.T) {
+	var flipped atomic.Bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "<html>ok</html>")
+	})
+	mux.HandleFunc("/flip", func(w http.ResponseWriter, r *http.Request) {
+		if flipped.Load() {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		fmt.Fprintln(w, "<html>ok</html>")
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	ctx := context.Background()
+	store := newMemStore(testLinks(map[string]string{
+		"ok":    ts.URL + "/",
+		"same":  ts.URL + "/",
+		"flip":  ts.URL + "/flip",
+		"extra": ts.URL + "/?extra",
+	}))
+	m := newMonitor(store, time.Hour, 2, 2, time.Second)
+
+	for i := range 3 {
+		if i == 2 {
+			flipped.Store(true)
+			if err := store.Delete(ctx, "extra"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := m.run(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report := m.report()
+	if got, want := report.Links, 3; got != want {
+		t.Errorf("got %d links want %d", got, want)
+	}
+	if got, want := report.Failing, 1; got != want {
+		t.Errorf("got %d failing want %d", got, want)
+	}
+	if report.LastRun.IsZero() {
+		t.Error("last run not recorded")
+	}
+
+	tests := []struct {
+		short  string
+		ok     bool
+		checks int
+	}{
+		{"flip", false, 2}, // failing first
+		{"ok", true, 2},
+		{"same", true, 2},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			lh := report.Health[i]
+			if lh.Short != tt.short || lh.OK() != tt.ok || len(lh.Checks) != tt.checks {
+				t.Errorf("got %s ok %t with %d checks want %s %t %d",
+					lh.Short, lh.OK(), len(lh.Checks), tt.short, tt.ok, tt.checks)
+			}
+		})
+	}
+	flip := report.Health[0]
+	if flip.Checks[0].Status != 404 || !flip.Checks[1].OK() || !flip.Checks[0].Checked.After(flip.Checks[1].Checked) {
+		t.Errorf("unexpected history, most recent first %+v", flip.Checks)
+	}
+	if flip.FailingSince == nil || !flip.FailingSince.Equal(flip.Checks[0].Checked) {
+		t.Errorf("failing since got %v want %v", flip.FailingSince, flip.Checks[0].Checked)
+	}
+	if since, reason, failing := m.failing("flip"); !failing || since.IsZero() || reason != "status 404" {
+		t.Errorf("failing got %v %q %t", since, reason, failing)
+	}
+	if _, _, failing := m.failing("ok"); failing {
+		t.Error("ok link reported failing")
+	}
+
+	// a changed target starts a new history
+	err := store.Put(ctx, Link{Short: "ok", Target: ts.URL + "/?moved"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.run(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, lh := range m.report().Health {
+		if lh.Short == "ok" && len(lh.Checks) != 1 {
+			t.Errorf("changed target kept %d old checks", len(lh.Checks)-1)
+		}
+	}
+}
+
+func TestMonitorHandlers(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer ts.Close()
+
+	ns, err := newServer(false, "", "", time.Second, 2, withMonitor(time.Hour, 3, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.monitor.store = newMemStore(testLinks(map[string]string{"gone": ts.URL}))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", ns.home)
+	mux.HandleFunc("GET /health", ns.health)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec
+	}
+	if body := get("/").Body.String(); !strings.Contains(body, "not been checked yet") {
+		t.Errorf("home page before checks:\n%s", body)
+	}
+
+	if err := ns.monitor.run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if body := get("/").Body.String(); !strings.Contains(body, "1 of 1 links failing") || !strings.Contains(body, "/gone</code> status") {
+		t.Errorf("home page after checks:\n%s", body)
+	}
+
+	rec := get("/health")
+	if got, want := rec.Header().Get("Content-Type"), "application/json"; got != want {
+		t.Errorf("content type got %s want %s", got, want)
+	}
+	var decoded struct {
+		Failing int `json:"failing"`
+		Health  []struct {
+			Short  string `json:"short"`
+			Checks []struct {
+				OK      bool   `json:"ok"`
+				Status  int    `json:"status"`
+				ErrType string `json:"error_type"`
+			} `json:"checks"`
+		} `json:"health"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Failing != 1 || len(decoded.Health) != 1 || len(decoded.Health[0].Checks) != 1 {
+		t.Fatalf("unexpected health report %+v", decoded)
+	}
+	if c := decoded.Health[0].Checks[0]; c.OK || c.Status != 410 || c.ErrType != "status" {
+		t.Errorf("unexpected check %+v", c)
+	}
+}
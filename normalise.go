@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// normalise matches short urls loosely, so that a short url typed from
+// print as /DBD, or pasted from an email as /dbd. or with "_" for "-",
+// finds the link /dbd. Each part of the normalisation policy is
+// optional. Short urls are kept as written, and are looked up by their
+// normalised form if there is no exact match; short urls which are the
+// same once normalised are reported when links are loaded.
+
+// normalisation policy options
+const (
+	normCase        = "case"        // fold case
+	normDashes      = "dashes"      // treat "_" as "-"
+	normPunctuation = "punctuation" // strip trailing punctuation
+)
+
+// trailingPunctuation is stripped from the end of a short url by the
+// punctuation option
+const trailingPunctuation = `.,;:!?'")]}>`
+
+// normaliser applies a normalisation policy to short urls
+type normaliser struct {
+	foldCase  bool
+	dashes    bool
+	trimPunct bool
+}
+
+// newNormaliser makes a normaliser from policy options
+func newNormaliser(options []string) (normaliser, error) {
+	var n normaliser
+	for _, o := range options {
+		switch strings.ToLower(strings.TrimSpace(o)) {
+		case normCase:
+			n.foldCase = true
+		case normDashes:
+			n.dashes = true
+		case normPunctuation:
+			n.trimPunct = true
+		default:
+			return n, fmt.Errorf("unknown normalisation %q, not one of %s, %s or %s", o, normCase, normDashes, normPunctuation)
+		}
+	}
+	return n, nil
+}
+
+// enabled reports if the normaliser changes short urls
+func (n normaliser) enabled() bool {
+	return n.foldCase || n.dashes || n.trimPunct
+}
+
+// key returns the normalised form of a short url
+func (n normaliser) key(su string) string {
+	if n.trimPunct {
+		su = strings.TrimRight(su, trailingPunctuation)
+	}
+	if n.dashes {
+		su = strings.ReplaceAll(su, "_", "-")
+	}
+	if n.foldCase {
+		su = strings.ToLower(su)
+	}
+	return su
+}
+
+// check reports links whose short urls are the same once normalised as
+// csvErrors, in line order
+func (n normaliser) check(links map[string]Link) error {
+	if !n.enabled() {
+		return nil
+	}
+	var errs csvErrors
+	for _, c := range nearDuplicates(links, n.key) {
+		errs = append(errs, &csvError{
+			Line: c.line,
+			Err:  fmt.Errorf("short url %s is the same as %s at line %d once normalised", c.Short, c.of.Short, c.of.line),
+		})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// index maps the normalised form of each short url to the short url
+func (n normaliser) index(links map[string]Link) map[string]string {
+	if !n.enabled() {
+		return nil
+	}
+	keys := make(map[string]string, len(links))
+	for su := range links {
+		keys[n.key(su)] = su
+	}
+	return keys
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestNewNormaliser(t *testing.T) {
+	tests := []struct {
+		options []string
+		want    normaliser
+		isErr   bool
+	}{
+		{nil, normaliser{}, false},
+		{[]string{"case"}, normaliser{foldCase: true}, false},
+		{[]string{"Dashes", " punctuation"}, normaliser{dashes: true, trimPunct: true}, false},
+		{[]string{"case", "case"}, normaliser{foldCase: true}, false},
+		{[]string{"spaces"}, normaliser{}, true},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			got, err := newNormaliser(tt.options)
+			if (err != nil) != tt.isErr {
+				t.Fatalf("got error %v want error %t", err, tt.isErr)
+			}
+			if !tt.isErr && got != tt.want {
+				t.Errorf("got %+v want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormaliserKey(t *testing.T) {
+	all := normaliser{foldCase: true, dashes: true, trimPunct: true}
+	tests := []struct {
+		n         normaliser
+		short, to string
+	}{
+		{normaliser{}, "Ab_c.", "Ab_c."},
+		{normaliser{foldCase: true}, "Ab_c.", "ab_c."},
+		{normaliser{dashes: true}, "Ab_c.", "Ab-c."},
+		{normaliser{trimPunct: true}, "Ab_c.", "Ab_c"},
+		{all, "Ab_c.", "ab-c"},
+		{all, "abc),", "abc"},
+		{all, `abc!?"'>]}`, "abc"},
+		{all, "abc-", "abc-"},
+		{all, "abc+", "abc+"},
+		{all, ".abc", ".abc"},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			if got := tt.n.key(tt.short); got != tt.to {
+				t.Errorf("%s got %s want %s", tt.short, got, tt.to)
+			}
+		})
+	}
+}
+
+func TestNormaliserCheck(t *testing.T) {
+	links := map[string]Link{
+		"abc":  {Short: "abc", line: 1},
+		"ABC":  {Short: "ABC", line: 3},
+		"d-ef": {Short: "d-ef", line: 2},
+		"Abc":  {Short: "Abc", line: 4},
+	}
+	if err := (normaliser{dashes: true}).check(links); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	err := (normaliser{foldCase: true}).check(links)
+	var errs csvErrors
+	if !errors.As(err, &errs) || len(errs) != 2 {
+		t.Fatalf("got %v want 2 csvErrors", err)
+	}
+	want := "line 3: short url ABC is the same as abc at line 1 once normalised\nline 4: short url Abc is the same as abc at line 1 once normalised"
+	if got := err.Error(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// passthrough carries parts of a request through to a link's target,
+// if the link's metadata asks for it. A query column of "merge" or
+// "override" merges the request's query parameters into the target's,
+// and a path column of "append" appends any path after the short url to
+// the target's path, so that /docs/getting-started can go to
+// https://docs.example.com/getting-started.
+//
+// Query merge rules, by parameter name:
+// * merge: the target's parameters are kept; request parameters are
+//   added only if the target has none of that name
+// * override: the request's parameters replace any of the target's of
+//   the same name
+// In both cases the target's parameters come first, in their order,
+// followed by the request's, in theirs, and repeated parameters are
+// kept together. Neither applies when redirecting to a fallback.
+
+// passthrough metadata columns and values
+const (
+	metaQuery     = "query"
+	metaPath      = "path"
+	queryMerge    = "merge"    // add request parameters the target lacks
+	queryOverride = "override" // request parameters replace the target's
+	pathAppend    = "append"   // append the path after the short url
+)
+
+// checkPassthrough checks that a link's query and path metadata, if set,
+// are known options
+func checkPassthrough(short string, meta map[string]string) error {
+	switch q := strings.ToLower(strings.TrimSpace(meta[metaQuery])); q {
+	case "", queryMerge, queryOverride:
+	default:
+		return fmt.Errorf("short url %s query %q is not %s or %s", short, q, queryMerge, queryOverride)
+	}
+	switch p := strings.ToLower(strings.TrimSpace(meta[metaPath])); p {
+	case "", pathAppend:
+	default:
+		return fmt.Errorf("short url %s path %q is not %s", short, p, pathAppend)
+	}
+	return nil
+}
+
+// appendsPath reports if a link appends the path after its short url to
+// its target
+func appendsPath(l Link) bool {
+	return strings.EqualFold(strings.TrimSpace(l.Meta[metaPath]), pathAppend)
+}
+
+// queryParam is a raw query parameter, as given, with its unescaped name
+type queryParam struct {
+	name, raw string
+}
+
+// queryParams splits a raw query string into its parameters
+func queryParams(raw string) []queryParam {
+	var params []queryParam
+	for _, p := range strings.Split(raw, "&") {
+		if p == "" {
+			continue
+		}
+		name, _, _ := strings.Cut(p, "=")
+		if n, err := url.QueryUnescape(name); err == nil {
+			name = n
+		}
+		params = append(params, queryParam{name, p})
+	}
+	return params
+}
+
+// mergeQuery merges the raw request query into the raw target query
+// according to the merge rules for option
+func mergeQuery(target, request, option string) string {
+	tp, rp := queryParams(target), queryParams(request)
+	if len(rp) == 0 {
+		return target
+	}
+	has := func(params []queryParam, name string) bool {
+		for _, p := range params {
+			if p.name == name {
+				return true
+			}
+		}
+		return false
+	}
+	var out []string
+	switch option {
+	case queryMerge:
+		for _, p := range tp {
+			out = append(out, p.raw)
+		}
+		for _, p := range rp {
+			if !has(tp, p.name) {
+				out = append(out, p.raw)
+			}
+		}
+	case queryOverride:
+		for _, p := range tp {
+			if !has(rp, p.name) {
+				out = append(out, p.raw)
+			}
+		}
+		for _, p := range rp {
+			out = append(out, p.raw)
+		}
+	default:
+		return target
+	}
+	return strings.Join(out, "&")
+}
+
+// checkSuffix checks that an escaped path suffix, and p, its unescaped
+// form, stay below the target's path, rejecting "." and ".." segments
+// and escaped slashes, which could otherwise reach other paths on the
+// target's host
+func checkSuffix(suffix, p string) error {
+	if strings.Contains(strings.ToLower(suffix), "%2f") {
+		return fmt.Errorf("invalid path %s: escaped slash", suffix)
+	}
+	for _, seg := range strings.Split(p, "/") {
+		if seg == "." || seg == ".." {
+			return fmt.Errorf("invalid path %s: %q segment", suffix, seg)
+		}
+	}
+	return nil
+}
+
+// passthrough returns a link's target with the request's query merged
+// and the path suffix appended, as set by the link's metadata. suffix
+// is the escaped path after the short url, without a leading "/".
+func passthrough(target string, l Link, r *http.Request, suffix string) (string, error) {
+	option := strings.ToLower(strings.TrimSpace(l.Meta[metaQuery]))
+	if r.URL.RawQuery == "" && suffix == "" || option == "" && !appendsPath(l) {
+		return target, nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return target, fmt.Errorf("could not parse target %s: %v", target, err)
+	}
+	if suffix != "" && appendsPath(l) {
+		p, err := url.PathUnescape(suffix)
+		if err != nil {
+			return target, fmt.Errorf("invalid path %s: %v", suffix, err)
+		}
+		if err := checkSuffix(suffix, p); err != nil {
+			return target, err
+		}
+		u.RawPath = strings.TrimSuffix(u.EscapedPath(), "/") + "/" + suffix
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + p
+	}
+	if option != "" {
+		u.RawQuery = mergeQuery(u.RawQuery, r.URL.RawQuery, option)
+	}
+	return u.String(), nil
+}
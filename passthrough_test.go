@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMergeQuery(t *testing.T) {
+	tests := []struct {
+		target, request, option string
+		want                    string
+	}{
+		{"", "", queryMerge, ""},
+		{"a=1", "", queryMerge, "a=1"},
+		{"", "b=2", queryMerge, "b=2"},
+		{"a=1", "b=2", queryMerge, "a=1&b=2"},
+		{"a=1", "a=9&b=2", queryMerge, "a=1&b=2"},                // target wins
+		{"a=1", "a=9&b=2", queryOverride, "a=9&b=2"},             // request wins
+		{"a=1&c=3", "c=9&a=8", queryOverride, "c=9&a=8"},         // request order
+		{"a=1&a=2&b=3", "a=9&a=8", queryMerge, "a=1&a=2&b=3"},    // repeated kept
+		{"a=1&a=2&b=3", "a=9&a=8", queryOverride, "b=3&a=9&a=8"}, // all replaced
+		{"x%20y=1", "x+y=2&z=%26", queryMerge, "x%20y=1&z=%26"},  // unescaped names
+		{"a=1", "&&b=2&", queryMerge, "a=1&b=2"},
+		{"utm_source=site", "utm_source=mail&utm_medium=email", queryMerge, "utm_source=site&utm_medium=email"},
+		{"a=1", "a=9", "", "a=1"},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			if got := mergeQuery(tt.target, tt.request, tt.option); got != tt.want {
+				t.Errorf("%s + %s (%s) got %s want %s", tt.target, tt.request, tt.option, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPassthrough(t *testing.T) {
+	meta := func(kv ...string) map[string]string {
+		m := map[string]string{}
+		for i := 0; i < len(kv); i += 2 {
+			m[kv[i]] = kv[i+1]
+		}
+		return m
+	}
+	tests := []struct {
+		target string
+		meta   map[string]string
+		path   string
+		suffix string
+		want   string
+		isErr  bool
+	}{
+		{"https://x/a?b=1", nil, "/s?c=2", "", "https://x/a?b=1", false},
+		{"https://x/a?b=1", meta("query", "merge"), "/s?c=2", "", "https://x/a?b=1&c=2", false},
+		{"https://x/a?b=1", meta("query", "Override"), "/s?b=2", "", "https://x/a?b=2", false},
+		{"https://x/a?b=1#top", meta("query", "merge"), "/s?c=2", "", "https://x/a?b=1&c=2#top", false},
+		{"https://x", meta("path", "append"), "/s/getting-started", "getting-started", "https://x/getting-started", false},
+		{"https://x/docs/", meta("path", "append"), "/s/a/b", "a/b", "https://x/docs/a/b", false},
+		{"https://x/docs?v=1", meta("path", "append"), "/s/a%20b/c", "a%20b/c", "https://x/docs/a%20b/c?v=1", false},
+		{"https://x/docs", meta("path", "append"), "/s/a%2Fb", "a%2Fb", "", true},
+		{"https://x/docs", meta("path", "append"), "/s/a%2fb", "a%2fb", "", true},
+		{"https://x/docs", meta("path", "append"), "/s/%2e%2e/admin", "%2e%2e/admin", "", true},
+		{"https://x/docs", meta("path", "append"), "/s/a/%2E", "a/%2E", "", true},
+		{"https://x/docs", meta("path", "append"), "/s/a/%2e%2e%2fadmin", "a/%2e%2e%2fadmin", "", true},
+		{"https://x/docs", meta("path", "append"), "/s/a..b/.c", "a..b/.c", "https://x/docs/a..b/.c", false},
+		{"https://x/docs", meta("query", "merge"), "/s/%2e%2e", "%2e%2e", "https://x/docs", false}, // path not appended
+		{"https://x/docs", meta("path", "append"), "/s/a?q=1", "a", "https://x/docs/a", false},
+		{"https://x/docs", meta("path", "append", "query", "merge"), "/s/a?q=1", "a", "https://x/docs/a?q=1", false},
+		{"https://x/docs", meta("query", "merge"), "/s/a", "a", "https://x/docs", false}, // path not appended
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			got, err := passthrough(tt.target, Link{Short: "s", Target: tt.target, Meta: tt.meta}, r, tt.suffix)
+			if (err != nil) != tt.isErr {
+				t.Fatalf("got error %v want error %t", err, tt.isErr)
+			}
+			if err != nil {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("got %s want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPassthrough(t *testing.T) {
+	tests := []struct {
+		meta  map[string]string
+		isErr bool
+	}{
+		{nil, false},
+		{map[string]string{"query": "merge", "path": "append"}, false},
+		{map[string]string{"query": "Override"}, false},
+		{map[string]string{"query": "replace"}, true},
+		{map[string]string{"path": "prepend"}, true},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			if err := checkPassthrough("s", tt.meta); (err != nil) != tt.isErr {
+				t.Errorf("got error %v want error %t", err, tt.isErr)
+			}
+		})
+	}
+
+	// in csv files
+	_, err := urls(strings.NewReader("short,target,query\nabc,https://abc,sometimes\n"))
+	if err == nil || !strings.Contains(err.Error(), `query "sometimes"`) {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+// TestCheckPassthroughStops checks that parsing stops at the first
+// record with an invalid passthrough option
+func TestCheckPassthroughStops(t *testing.T) {
+	m, err := urls(strings.NewReader("short,target,query\nabc,https://a,bogus\ndef,https://d,merge\nghi,https://g,\n"))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if len(m) != 0 {
+		t.Errorf("got links %v after the first bad record", m)
+	}
+}
+
+func TestPassthroughRedirects(t *testing.T) {
+	path := tmpLinkFile(t, "short,target,status,query,path,disabled\n"+
+		"docs,https://docs.example.com,302,,append,\n"+
+		"utm,https://example.com/?utm_source=site,,merge,,\n"+
+		"plain,https://plain,,,,\n"+
+		"off,https://off,,,append,true\n")
+	ns, err := newServer(false, "", "", 0, 0, withDataFile(path, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path     string
+		status   int
+		location string
+	}{
+		{"/docs/getting-started", 302, "https://docs.example.com/getting-started"},
+		{"/docs/a/b?x=1", 302, "https://docs.example.com/a/b"},
+		{"/docs", 302, "https://docs.example.com"},
+		{"/docs/", 302, "https://docs.example.com"},
+		{"/docs/%2e%2e/x", 400, ""},
+		{"/docs/a%2Fb", 400, ""},
+		{"/utm?utm_source=mail&id=7", 301, "https://example.com/?utm_source=site&id=7"},
+		{"/plain?id=7", 301, "https://plain"},
+		{"/plain/extra", 404, ""},
+		{"/off/extra", 404, ""},
+		{"/nope/extra", 404, ""},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			ns.mux().ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+			if rec.Code != tt.status {
+				t.Errorf("%s status got %d want %d", tt.path, rec.Code, tt.status)
+			}
+			if got := rec.Header().Get("Location"); got != tt.location {
+				t.Errorf("%s location got %s want %s", tt.path, got, tt.location)
+			}
+		})
+	}
+	if got := ns.clicks.get("docs"); got != 4 {
+		t.Errorf("got %d clicks want 4", got)
+	}
+	rec := httptest.NewRecorder()
+	ns.mux().ServeHTTP(rec, httptest.NewRequest("GET", "/plain/extra", nil))
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), "plain/extra") {
+		t.Errorf("unexpected response %d %s", rec.Code, rec.Body)
+	}
+}
+
+// TestPassthroughFallback checks that nothing is passed through to a
+// fallback, even one which is the same as the link's target
+func TestPassthroughFallback(t *testing.T) {
+	longAgo := time.Now().Add(-48 * time.Hour)
+	failed := healthCheck{time.Now(), checkResult{Status: http.StatusNotFound}}
+	meta := func(fallback string) map[string]string {
+		return map[string]string{metaQuery: queryMerge, metaPath: pathAppend, metaFallback: fallback}
+	}
+	links := map[string]Link{
+		"dead": {Short: "dead", Target: "https://dead", Meta: meta("https://archive/dead")},
+		"same": {Short: "same", Target: "https://same", Meta: meta("https://same")},
+	}
+	ns, err := newServer(false, "", "", time.Second, 2, withMonitor(time.Hour, 3, 1), withFallback(time.Hour, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns.store = newMemStore(links)
+	ns.monitor.health = map[string]linkHealth{
+		"dead": {Short: "dead", Target: "https://dead", FailingSince: &longAgo, Checks: []healthCheck{failed}},
+		"same": {Short: "same", Target: "https://same", FailingSince: &longAgo, Checks: []healthCheck{failed}},
+	}
+
+	tests := []struct {
+		path     string
+		location string
+	}{
+		{"/dead?id=7", "https://archive/dead"},
+		{"/dead/page", "https://archive/dead"},
+		{"/same?id=7", "https://same"},
+		{"/same/page", "https://same"},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			ns.mux().ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+			if rec.Code != http.StatusFound || rec.Header().Get("Location") != tt.location {
+				t.Errorf("%s got %d %s want 302 %s", tt.path, rec.Code, rec.Header().Get("Location"), tt.location)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"html"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// preview shows where a short url goes without redirecting, so that
+// readers can check a link before following it. A preview is shown by
+// adding "+" to a short url, as in /{short}+, or a preview=1 query to it.
+
+// metaTitle is the link metadata column giving the title of a link's
+// target page
+const metaTitle = "title"
+
+// previewSuffix marks a short url to be previewed; it is not valid in a
+// short url
+const previewSuffix = "+"
+
+// wantsPreview reports if a short url request asks for a preview,
+// returning the short url without any preview suffix
+func wantsPreview(r *http.Request, shortURL string) (string, bool) {
+	if strings.HasSuffix(shortURL, previewSuffix) {
+		return strings.TrimSuffix(shortURL, previewSuffix), true
+	}
+	return shortURL, r.URL.Query().Get("preview") == "1"
+}
+
+// showPreview shows the destination of a short url, its title and the
+// result of its last health check, with a link to continue
+func (s *server) showPreview(w http.ResponseWriter, r *http.Request, shortURL string) {
+	link, err := s.store.Lookup(r.Context(), shortURL)
+	if err == nil && linkDisabled(link) {
+		err = linkNotFoundError
+	}
+	if err != nil {
+		if !errors.Is(err, linkNotFoundError) {
+			log.Printf("lookup error for %s: %v", shortURL, err)
+			http.Error(w, "link lookup error", http.StatusInternalServerError)
+			return
+		}
+		s.notFound(w, shortURL, s.shown(s.suggest(r.Context(), shortURL)))
+		return
+	}
+
+	target, _, fallback := s.linkTarget(link)
+	vars := struct {
+		Title, Short, Target, Original, PageTitle string
+		Fallback                                  bool
+		Check                                     *healthCheck
+	}{
+		Title:     "Preview " + link.Short,
+		Short:     link.Short,
+		Target:    target,
+		Original:  link.Target,
+		PageTitle: link.Meta[metaTitle],
+		Fallback:  fallback,
+	}
+	if s.monitor != nil {
+		if check, ok := s.monitor.lastCheck(link.Short); ok {
+			vars.Check = &check
+		}
+	}
+	err = s.previewTpl.Execute(w, vars)
+	if err != nil {
+		errorOutput(w, "preview", err)
+	}
+}
+
+// notFound reports that a short url was not found, with any suggested
+// links
+func (s *server) notFound(w http.ResponseWriter, shortURL string, sugg []suggestion) {
+	vars := struct {
+		Title, URL  string
+		InvalidPath bool
+		Suggestions []suggestion
+	}{"Redirection not found", html.EscapeString(shortURL), false, sugg}
+	w.WriteHeader(http.StatusNotFound)
+	err := s.notFoundTpl.Execute(w, vars)
+	if err != nil {
+		errorOutput(w, "redirection not found", err)
+	}
+}
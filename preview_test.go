@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreview(t *testing.T) {
+	ns, err := newServer(false, "", "", time.Second, 2, withMonitor(time.Hour, 3, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := testLinks(map[string]string{
+		"abc":  "https://abc.example.com/page",
+		"dead": "https://dead.example.com",
+		"new":  "https://new.example.com",
+	})
+	abc := links["abc"]
+	abc.Meta = map[string]string{metaTitle: "The ABC <Page>"}
+	links["abc"] = abc
+	dead := links["dead"]
+	dead.Meta = map[string]string{metaFallback: "https://archive.example.com/dead"}
+	links["dead"] = dead
+	ns.store = newMemStore(links)
+
+	longAgo := time.Now().Add(-48 * time.Hour)
+	ns.monitor.health = map[string]linkHealth{
+		"abc":  {Short: "abc", Target: abc.Target, Checks: []healthCheck{{time.Now(), checkResult{Status: 200}}}},
+		"dead": {Short: "dead", Target: dead.Target, FailingSince: &longAgo, Checks: []healthCheck{{time.Now(), checkResult{Status: 404}}}},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{shortURL}", ns.redirector)
+
+	tests := []struct {
+		url      string
+		status   int
+		contains []string
+	}{
+		{"/abc+", 200, []string{`href="https://abc.example.com/page"`, "The ABC &lt;Page&gt;", "working when last checked"}},
+		{"/abc+", 200, []string{"/abc</code>", "Continue"}},
+		{"/abc?preview=1", 200, []string{"https://abc.example.com/page"}},
+		{"/abc?preview=0", 301, nil},
+		{"/abc", 301, nil},
+		{"/new+", 200, []string{"has not been checked"}},
+		{"/dead+", 200, []string{`href="https://archive.example.com/dead"`, "https://dead.example.com", "alternative"}},
+		{"/nope+", 404, []string{"was not found"}},
+		{"/nope?preview=1", 404, []string{"was not found"}},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest("GET", tt.url, nil))
+			if rec.Code != tt.status {
+				t.Errorf("%s status got %d want %d", tt.url, rec.Code, tt.status)
+			}
+			body := rec.Body.String()
+			for _, c := range tt.contains {
+				if !strings.Contains(body, c) {
+					t.Errorf("%s body does not contain %s:\n%s", tt.url, c, body)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// reload watches a csv link file on the local filesystem, reloading it
+// into a csvStore when its modification time changes or when the
+// process receives a SIGHUP. A file which fails validation is reported
+// and the store continues to serve the previous set of links.
+
+const defaultDataPoll time.Duration = 2 * time.Second
+
+// csvReloader records data about a watched csv link file
+type csvReloader struct {
+	path    string
+	store   *csvStore
+	updated time.Time
+}
+
+// newCSVReloader loads the csv file at path into a new csvStore, which
+// looks up short urls normalised by norm
+func newCSVReloader(path string, norm normaliser) (*csvReloader, error) {
+	c := csvReloader{path: path, store: &csvStore{memStore: newNormalisedStore(nil, norm), path: path}}
+	_, err := c.reload(true)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// reload parses the csv file if it has been modified since it was last
+// loaded, or if force is set, and swaps the new links into the store.
+// The store is left unchanged if the file is invalid.
+func (c *csvReloader) reload(force bool) (reloaded bool, err error) {
+	stat, err := os.Stat(c.path)
+	if err != nil {
+		return false, fmt.Errorf("could not get data file details %s: %v", c.path, err)
+	}
+	updated := stat.ModTime()
+	if !force && !updated.After(c.updated) {
+		return false, nil
+	}
+	c.updated = updated // only report an invalid file once
+
+	f, err := os.Open(c.path)
+	if err != nil {
+		return false, fmt.Errorf("could not open data file %s: %v", c.path, err)
+	}
+	defer f.Close()
+	m, err := validateURLs(f)
+	if err == nil {
+		err = c.store.norm.check(m)
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not load urls from %s:\n%w", c.path, err)
+	}
+	c.store.replace(m)
+	return true, nil
+}
+
+// watch polls the csv file every interval and listens for SIGHUP,
+// reloading the file as needed until the context is cancelled
+func (c *csvReloader) watch(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultDataPoll
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	c.poll(ctx, interval, hup)
+}
+
+// poll polls the csv file every interval, and reloads it whenever a
+// signal is received on hup, until the context is cancelled
+func (c *csvReloader) poll(ctx context.Context, interval time.Duration, hup <-chan os.Signal) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		var reloaded bool
+		var err error
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Printf("SIGHUP received, reloading %s", c.path)
+			reloaded, err = c.reload(true)
+		case <-ticker.C:
+			reloaded, err = c.reload(false)
+		}
+		if err != nil {
+			log.Printf("data file reload error, serving previous links: %v", err)
+			continue
+		}
+		if reloaded {
+			log.Printf("reloaded links from %s", c.path)
+		}
+	}
+}
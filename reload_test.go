@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// writeDataFile writes contents to path, setting the modification time
+// to mtime
+func writeDataFile(t *testing.T, path, contents string, mtime time.Time) {
+	t.Helper()
+	err := os.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(path, mtime, mtime)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCSVReloader(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.csv")
+	now := time.Now()
+
+	writeDataFile(t, path, "abc,https://abc\n", now)
+	c, err := newCSVReloader(path, normaliser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.store.Lookup(ctx, "abc"); err != nil {
+		t.Fatalf("abc lookup error %v", err)
+	}
+
+	// unchanged file is not reloaded
+	reloaded, err := c.reload(false)
+	if err != nil || reloaded {
+		t.Errorf("unchanged file reloaded %t err %v", reloaded, err)
+	}
+
+	// changed file is reloaded
+	writeDataFile(t, path, "def,https://def\n", now.Add(time.Second))
+	reloaded, err = c.reload(false)
+	if err != nil || !reloaded {
+		t.Fatalf("changed file reloaded %t err %v", reloaded, err)
+	}
+	if _, err := c.store.Lookup(ctx, "abc"); !errors.Is(err, linkNotFoundError) {
+		t.Errorf("abc should have been removed, got %v", err)
+	}
+	if _, err := c.store.Lookup(ctx, "def"); err != nil {
+		t.Errorf("def lookup error %v", err)
+	}
+
+	// invalid file leaves the previous links in place, and is only
+	// reported once
+	writeDataFile(t, path, "ghi,not-a-url\n", now.Add(2*time.Second))
+	reloaded, err = c.reload(false)
+	if err == nil || reloaded {
+		t.Errorf("invalid file reloaded %t err %v", reloaded, err)
+	}
+	if _, err := c.store.Lookup(ctx, "def"); err != nil {
+		t.Errorf("def should still be served, got %v", err)
+	}
+	_, err = c.reload(false)
+	if err != nil {
+		t.Errorf("invalid file should only be reported once, got %v", err)
+	}
+
+	// a file with links which are the same once normalised is invalid
+	c.store.norm = normaliser{foldCase: true}
+	writeDataFile(t, path, "jkl,https://jkl\nJKL,https://jkl\n", now.Add(3*time.Second))
+	reloaded, err = c.reload(false)
+	if err == nil || reloaded {
+		t.Errorf("colliding file reloaded %t err %v", reloaded, err)
+	}
+	if _, err := c.store.Lookup(ctx, "def"); err != nil {
+		t.Errorf("def should still be served, got %v", err)
+	}
+
+	// missing file
+	_, err = newCSVReloader(filepath.Join(t.TempDir(), "missing.csv"), normaliser{})
+	if err == nil {
+		t.Error("expected error for missing file")
+	}
+}
+
+// TestCSVReloaderWatch checks reloading on file change and on SIGHUP
+func TestCSVReloaderWatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	path := filepath.Join(t.TempDir(), "links.csv")
+	now := time.Now()
+	writeDataFile(t, path, "abc,https://abc\n", now)
+	c, err := newCSVReloader(path, normaliser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hup := make(chan os.Signal)
+	go c.poll(ctx, 10*time.Millisecond, hup)
+
+	waitFor := func(short string) {
+		t.Helper()
+		for range 100 {
+			if _, err := c.store.Lookup(ctx, short); err == nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("%s was not loaded", short)
+	}
+
+	writeDataFile(t, path, "def,https://def\n", now.Add(time.Second))
+	waitFor("def")
+
+	// rewrite the file with the same modification time and signal
+	writeDataFile(t, path, "ghi,https://ghi\n", now.Add(time.Second))
+	hup <- syscall.SIGHUP
+	waitFor("ghi")
+}
+
+// TestRedirectsDuringReload hammers the redirector while the data file
+// is repeatedly reloaded. Run with the race detector.
+func TestRedirectsDuringReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.csv")
+	now := time.Now()
+	writeDataFile(t, path, "abc,https://abc/0\n", now)
+
+	ns, err := newServer(false, "", "", 0, 0, withDataFile(path, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{shortURL}", ns.redirector)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				rec := httptest.NewRecorder()
+				mux.ServeHTTP(rec, httptest.NewRequest("GET", "/abc", nil))
+				if rec.Code != http.StatusMovedPermanently {
+					t.Errorf("unexpected status %d", rec.Code)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := range 50 {
+		contents := fmt.Sprintf("abc,https://abc/%d\n", i)
+		writeDataFile(t, path, contents, now.Add(time.Duration(i+1)*time.Second))
+		if _, err := ns.reloader.reload(false); err != nil {
+			t.Error(err)
+		}
+	}
+	close(done)
+	wg.Wait()
+}
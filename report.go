@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// report writes link check results as text or a table for people, as
+// json or JUnit XML for machines such as CI jobs, or as a csv file of
+// redirected links for updating the link file.
+
+// writeText writes the failed checks and a summary in a simple text
+// format, returning the number of failures
+func writeText(w io.Writer, results []checkResult) (int, error) {
+	errorCount := 0
+	for _, rr := range results {
+		if rr.OK() {
+			continue
+		}
+		errorCount++
+		var err error
+		if rr.Err != nil {
+			_, err = fmt.Fprintf(w, "%s\n   %v\n", rr.URL, rr.Err)
+		} else {
+			_, err = fmt.Fprintf(w, "%s\n   status %d\n", rr.URL, rr.Status)
+		}
+		if err != nil {
+			return errorCount, err
+		}
+	}
+	_, err := fmt.Fprintf(w, "url check reported %d errors in %d url checks\n", errorCount, len(results))
+	return errorCount, err
+}
+
+// linkCheck is the check result for the target of a short url
+type linkCheck struct {
+	Short string
+	checkResult
+}
+
+// linkChecks fans out the results of checking the targets of links to
+// each short url, in the order of links
+func linkChecks(links []Link, results []checkResult) []linkCheck {
+	byURL := map[string]checkResult{}
+	for _, r := range results {
+		byURL[r.URL] = r
+	}
+	checks := make([]linkCheck, 0, len(links))
+	for _, l := range links {
+		r, ok := byURL[l.Target]
+		if !ok {
+			continue
+		}
+		checks = append(checks, linkCheck{Short: l.Short, checkResult: r})
+	}
+	return checks
+}
+
+// failures returns the number of failed checks
+func failures(checks []linkCheck) int {
+	n := 0
+	for _, c := range checks {
+		if !c.OK() {
+			n++
+		}
+	}
+	return n
+}
+
+// errorMessage returns the error message for a failed check
+func (c linkCheck) errorMessage() string {
+	if c.Err != nil {
+		return c.Err.Error()
+	}
+	if !c.OK() {
+		return fmt.Sprintf("status %d", c.Status)
+	}
+	return ""
+}
+
+// MarshalJSON encodes the check with its error as a string and its
+// latency in milliseconds
+func (c linkCheck) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Short     string  `json:"short"`
+		URL       string  `json:"url"`
+		OK        bool    `json:"ok"`
+		Status    int     `json:"status"`
+		LatencyMS float64 `json:"latency_ms"`
+		Redirects []hop   `json:"redirects,omitempty"`
+		FinalURL  string  `json:"final_url,omitempty"`
+		Attempts  int     `json:"attempts,omitempty"`
+		ErrType   string  `json:"error_type,omitempty"`
+		Error     string  `json:"error,omitempty"`
+	}{
+		Short:     c.Short,
+		URL:       c.URL,
+		OK:        c.OK(),
+		Status:    c.Status,
+		LatencyMS: float64(c.Duration.Microseconds()) / 1000,
+		Redirects: c.Redirects,
+		FinalURL:  c.FinalURL,
+		Attempts:  c.Attempts,
+		ErrType:   c.ErrType(),
+		Error:     c.errorMessage(),
+	})
+}
+
+// writeTable writes the checks as a text table
+func writeTable(w io.Writer, checks []linkCheck) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "short\tstatus\tlatency\terror\turl")
+	for _, c := range checks {
+		status := "-"
+		if c.Status != 0 {
+			status = fmt.Sprint(c.Status)
+		}
+		errType := c.ErrType()
+		if errType == "" {
+			errType = "-"
+		}
+		target := c.URL
+		if c.FinalURL != "" && c.FinalURL != c.URL {
+			target = c.URL + " -> " + c.FinalURL
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			c.Short, status, c.Duration.Round(time.Millisecond), errType, target)
+	}
+	err := tw.Flush()
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%d failures in %d checks\n", failures(checks), len(checks))
+	return err
+}
+
+// writeJSON writes the checks as a json array
+func writeJSON(w io.Writer, checks []linkCheck) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(checks)
+}
+
+// writeRedirects writes a csv file of the links whose targets redirect,
+// with the final url reached and any problems found with the redirect
+// chain, so that the link file can be updated to point at the final url
+// directly
+func writeRedirects(w io.Writer, checks []linkCheck) error {
+	c := csv.NewWriter(w)
+	err := c.Write([]string{"short", "target", "final_url", "hops", "status", "problem"})
+	if err != nil {
+		return err
+	}
+	for _, lc := range checks {
+		if len(lc.Redirects) == 0 {
+			continue
+		}
+		record := []string{
+			lc.Short, lc.URL, lc.FinalURL,
+			strconv.Itoa(len(lc.Redirects)), strconv.Itoa(lc.Status),
+			strings.ReplaceAll(lc.errorMessage(), "\n", "; "),
+		}
+		if err := c.Write(record); err != nil {
+			return err
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
+
+// junit XML types, as read by CI systems
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// writeJUnit writes the checks as JUnit XML, with a test case for each
+// short url named by its target host
+func writeJUnit(w io.Writer, checks []linkCheck) error {
+	seconds := func(d time.Duration) string {
+		return fmt.Sprintf("%.3f", d.Seconds())
+	}
+	suite := junitTestSuite{
+		Name:     "link check",
+		Tests:    len(checks),
+		Failures: failures(checks),
+	}
+	var total time.Duration
+	for _, c := range checks {
+		total += c.Duration
+		tc := junitTestCase{Name: c.Short, ClassName: c.URL, Time: seconds(c.Duration)}
+		if u, err := url.Parse(c.URL); err == nil && u.Host != "" {
+			tc.ClassName = u.Host
+		}
+		if !c.OK() {
+			tc.Failure = &junitFailure{
+				Message: c.errorMessage(),
+				Type:    c.ErrType(),
+				Text:    c.URL,
+			}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suite.Time = seconds(total)
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
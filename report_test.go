@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testChecks returns link checks against a local test server
+func testChecks(t *testing.T) []linkCheck {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html>ok</html>"))
+	})
+	mux.HandleFunc("/404", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	mux.HandleFunc("/moved", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/final", http.StatusMovedPermanently)
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	links := []Link{
+		{Short: "ok", Target: ts.URL + "/"},
+		{Short: "ok-too", Target: ts.URL + "/"},
+		{Short: "missing", Target: ts.URL + "/404"},
+		{Short: "moved", Target: ts.URL + "/moved"},
+	}
+	targets := []string{ts.URL + "/", ts.URL + "/404", ts.URL + "/moved"}
+	g := NewGetClient(2, time.Second)
+	results, err := g.Check(context.Background(), targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return linkChecks(links, results)
+}
+
+func TestLinkChecks(t *testing.T) {
+	checks := testChecks(t)
+	if got, want := len(checks), 4; got != want {
+		t.Fatalf("got %d checks want %d", got, want)
+	}
+	if got, want := failures(checks), 1; got != want {
+		t.Errorf("got %d failures want %d", got, want)
+	}
+	moved := checks[3]
+	if moved.Short != "moved" || !moved.OK() || !strings.HasSuffix(moved.FinalURL, "/final") {
+		t.Errorf("unexpected moved check %+v", moved)
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeTable(&buf, testChecks(t)); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"short", "missing", "404", "status", "/moved -> http", "1 failures in 4 checks"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("table does not contain %q:\n%s", want, buf.String())
+		}
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeJSON(&buf, testChecks(t)); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []struct {
+		Short     string  `json:"short"`
+		OK        bool    `json:"ok"`
+		Status    int     `json:"status"`
+		LatencyMS float64 `json:"latency_ms"`
+		FinalURL  string  `json:"final_url"`
+		ErrType   string  `json:"error_type"`
+		Error     string  `json:"error"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(decoded), 4; got != want {
+		t.Fatalf("got %d results want %d", got, want)
+	}
+	missing := decoded[2]
+	if missing.OK || missing.Status != 404 || missing.ErrType != "status" || missing.Error != "status 404" {
+		t.Errorf("unexpected missing result %+v", missing)
+	}
+	if decoded[0].LatencyMS <= 0 || decoded[0].FinalURL == "" {
+		t.Errorf("unexpected ok result %+v", decoded[0])
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	var buf bytes.Buffer
+	if err := writeJUnit(&buf, testChecks(t)); err != nil {
+		t.Fatal(err)
+	}
+	var suites junitTestSuites
+	if err := xml.Unmarshal(buf.Bytes(), &suites); err != nil {
+		t.Fatal(err)
+	}
+	if len(suites.Suites) != 1 {
+		t.Fatalf("expected one suite, got %d", len(suites.Suites))
+	}
+	suite := suites.Suites[0]
+	if suite.Tests != 4 || suite.Failures != 1 || len(suite.Cases) != 4 {
+		t.Errorf("unexpected suite %+v", suite)
+	}
+	if f := suite.Cases[2].Failure; f == nil || f.Type != "status" {
+		t.Errorf("unexpected failure for missing %+v", suite.Cases[2])
+	}
+	if !strings.HasPrefix(suite.Cases[0].ClassName, "127.0.0.1") {
+		t.Errorf("unexpected classname %s", suite.Cases[0].ClassName)
+	}
+}
+
+func TestWriteRedirects(t *testing.T) {
+	var buf bytes.Buffer
+	checks := testChecks(t)
+	checks = append(checks, linkCheck{Short: "gone", checkResult: checkResult{
+		URL:       "https://example.com/gone",
+		Status:    200,
+		Redirects: []hop{{"https://example.com/gone", 301}},
+		FinalURL:  "https://example.com/",
+		Err:       errors.Join(RootRedirect, TooManyRedirects),
+	}})
+	if err := writeRedirects(&buf, checks); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(records), 3; got != want {
+		t.Fatalf("got %d records want %d", got, want)
+	}
+	if got, want := strings.Join(records[0], ","), "short,target,final_url,hops,status,problem"; got != want {
+		t.Errorf("header got %s want %s", got, want)
+	}
+	moved, gone := records[1], records[2]
+	if moved[0] != "moved" || !strings.HasSuffix(moved[2], "/final") || moved[3] != "1" || moved[5] != "" {
+		t.Errorf("unexpected moved record %v", moved)
+	}
+	if gone[0] != "gone" || !strings.Contains(gone[5], "redirect to site root; too many redirects") {
+		t.Errorf("unexpected gone record %v", gone)
+	}
+}
+
+func TestCheckCommand(t *testing.T) {
+	buf := commandOutput(t)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/404":
+			w.WriteHeader(http.StatusNotFound)
+		case "/hang":
+			<-r.Context().Done()
+		default:
+			w.Write([]byte("<html>ok</html>"))
+		}
+	}))
+	defer ts.Close()
+
+	good := tmpLinkFile(t, "abc,"+ts.URL+"/\n")
+	c := checkCommand{Timeout: time.Second, Workers: 2, Format: "json"}
+	c.Args.File = good
+	if err := c.Execute(nil); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+	if !strings.Contains(buf.String(), `"short": "abc"`) {
+		t.Errorf("unexpected output %s", buf.String())
+	}
+
+	bad := tmpLinkFile(t, "abc,"+ts.URL+"/\ndef,"+ts.URL+"/404\n")
+	c.Args.File = bad
+	c.Format = "table"
+	if err := c.Execute(nil); err == nil {
+		t.Error("expected error for failed check")
+	}
+
+	// partial results are reported at the deadline
+	buf.Reset()
+	hang := tmpLinkFile(t, "abc,"+ts.URL+"/\ndef,"+ts.URL+"/hang\n")
+	c.Args.File = hang
+	c.Deadline = 100 * time.Millisecond
+	err := c.Execute(nil)
+	if err == nil || !strings.Contains(err.Error(), "check stopped after 1 of 2 urls") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if !strings.Contains(buf.String(), "abc") || strings.Contains(buf.String(), "def") {
+		t.Errorf("unexpected partial output %s", buf.String())
+	}
+}
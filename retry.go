@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retry retries url checks that fail for reasons which may be
+// transient, such as a timeout or a 503 response, waiting with
+// exponential backoff and jitter or for as long as a Retry-After header
+// asks. A host limiter caps the concurrency and rate of requests to each
+// host, so that hosts appearing many times in a link file are not
+// flooded by the worker pool.
+
+// maxRetryAfter is the longest Retry-After wait honoured; checks asked
+// to wait longer are not retried
+const maxRetryAfter = 30 * time.Second
+
+// retryPolicy sets how failed checks are retried. Retries of 0 means
+// checks are not retried.
+type retryPolicy struct {
+	retries    int           // retries after the first attempt
+	backoff    time.Duration // wait before the first retry, doubled for each retry
+	maxBackoff time.Duration // longest wait between retries
+}
+
+// retryable reports if a check failed in a way that may succeed if
+// tried again
+func retryable(r checkResult) bool {
+	switch r.Status {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	if r.Err == nil {
+		return false
+	}
+	switch r.ErrType() {
+	case "timeout", "connection":
+		return true
+	}
+	return false
+}
+
+// wait returns the time to wait before retry number n, counting from
+// 1. Half of the exponential backoff is fixed and half is random jitter,
+// to spread out retries from workers which failed together.
+func (p retryPolicy) wait(n int) time.Duration {
+	d := p.backoff << (n - 1)
+	if d <= 0 || (p.maxBackoff > 0 && d > p.maxBackoff) {
+		d = p.maxBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + rand.N(d/2+1)
+}
+
+// parseRetryAfter parses a Retry-After header value, which may be a
+// number of seconds or an http date, returning false if it is missing
+// or invalid
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	if d := t.Sub(now); d > 0 {
+		return d, true
+	}
+	return 0, true
+}
+
+// sleep waits for d or until the context is cancelled
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// hostLimiter limits the number of concurrent requests to each host and,
+// if interval is set, the rate at which requests to each host start
+type hostLimiter struct {
+	limit    int           // concurrent requests per host, unlimited if 0
+	interval time.Duration // minimum time between requests to a host
+	mu       sync.Mutex
+	hosts    map[string]*hostSlot
+
+	// the clock, replaceable for testing
+	now   func() time.Time
+	sleep func(context.Context, time.Duration) error
+}
+
+// hostSlot is the state of requests to a host
+type hostSlot struct {
+	sem  chan struct{}
+	next time.Time // earliest start of the next request
+}
+
+// newHostLimiter makes a hostLimiter
+func newHostLimiter(limit int, interval time.Duration) *hostLimiter {
+	return &hostLimiter{
+		limit:    limit,
+		interval: interval,
+		hosts:    map[string]*hostSlot{},
+		now:      time.Now,
+		sleep:    sleep,
+	}
+}
+
+// slot returns the slot for a host, making it if needed
+func (h *hostLimiter) slot(host string) *hostSlot {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.hosts[host]
+	if !ok {
+		s = &hostSlot{}
+		if h.limit > 0 {
+			s.sem = make(chan struct{}, h.limit)
+		}
+		h.hosts[host] = s
+	}
+	return s
+}
+
+// acquire waits until a request to the host of u may start, returning a
+// function to call when the request is done
+func (h *hostLimiter) acquire(ctx context.Context, u string) (func(), error) {
+	release := func() {}
+	if h == nil {
+		return release, nil
+	}
+	host := u
+	if pu, err := url.Parse(u); err == nil && pu.Host != "" {
+		host = strings.ToLower(pu.Host)
+	}
+	s := h.slot(host)
+
+	if s.sem != nil {
+		select {
+		case s.sem <- struct{}{}:
+			release = func() { <-s.sem }
+		case <-ctx.Done():
+			return release, ctx.Err()
+		}
+	}
+	if h.interval > 0 {
+		h.mu.Lock()
+		now := h.now()
+		start := s.next
+		if start.Before(now) {
+			start = now
+		}
+		s.next = start.Add(h.interval)
+		h.mu.Unlock()
+		if err := h.sleep(ctx, start.Sub(now)); err != nil {
+			release()
+			return func() {}, err
+		}
+	}
+	return release, nil
+}
+
+// retryFetch fetches a url, retrying failures which may be transient
+// according to the client's retry policy. Requests wait for the
+// client's host limiter. The result of the last attempt is returned.
+func (g *getClient) retryFetch(ctx context.Context, u string) checkResult {
+	var r checkResult
+	for attempt := 0; ; attempt++ {
+		release, err := g.hosts.acquire(ctx, u)
+		if err != nil {
+			if attempt == 0 {
+				return checkResult{URL: u, Err: err}
+			}
+			r.Err = errors.Join(r.Err, err)
+			return r
+		}
+		r = g.fetch(ctx, u)
+		release()
+		r.Attempts = attempt + 1
+
+		if attempt >= g.retry.retries || !retryable(r) || ctx.Err() != nil {
+			return r
+		}
+		wait := g.retry.wait(attempt + 1)
+		if r.retryAfter > 0 {
+			if r.retryAfter > maxRetryAfter {
+				return r
+			}
+			wait = r.retryAfter
+		}
+		if err := sleep(ctx, wait); err != nil {
+			r.Err = errors.Join(r.Err, err)
+			return r
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryWait(t *testing.T) {
+	p := retryPolicy{retries: 5, backoff: 100 * time.Millisecond, maxBackoff: time.Second}
+	tests := []struct {
+		n        int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{3, 200 * time.Millisecond, 400 * time.Millisecond},
+		{5, 500 * time.Millisecond, time.Second},  // capped
+		{70, 500 * time.Millisecond, time.Second}, // overflow capped
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			for range 50 {
+				if d := p.wait(tt.n); d < tt.min || d > tt.max {
+					t.Fatalf("wait %d got %v want between %v and %v", tt.n, d, tt.min, tt.max)
+				}
+			}
+		})
+	}
+	if d := (retryPolicy{}).wait(1); d != 0 {
+		t.Errorf("zero policy wait got %v", d)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		wait  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"0", 0, true},
+		{"120", 2 * time.Minute, true},
+		{" 5 ", 5 * time.Second, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{"Fri, 01 Mar 2024 12:00:30 GMT", 30 * time.Second, true},
+		{"Fri, 01 Mar 2024 11:00:00 GMT", 0, true}, // in the past
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			wait, ok := parseRetryAfter(tt.value, now)
+			if wait != tt.wait || ok != tt.ok {
+				t.Errorf("%q got %v %t want %v %t", tt.value, wait, ok, tt.wait, tt.ok)
+			}
+		})
+	}
+}
+
+func TestRetryFetch(t *testing.T) {
+	var flaky, limited, gone atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/flaky", func(w http.ResponseWriter, r *http.Request) {
+		if flaky.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "<html>ok</html>")
+	})
+	mux.HandleFunc("/limited", func(w http.ResponseWriter, r *http.Request) {
+		if limited.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		fmt.Fprintln(w, "<html>ok</html>")
+	})
+	mux.HandleFunc("/later", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc("/gone", func(w http.ResponseWriter, r *http.Request) {
+		gone.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	g := NewGetClient(1, time.Second)
+	g.retry = retryPolicy{retries: 2, backoff: 10 * time.Millisecond, maxBackoff: 50 * time.Millisecond}
+
+	tests := []struct {
+		path     string
+		ok       bool
+		attempts int
+		minTime  time.Duration
+	}{
+		{"/flaky", true, 3, 0},
+		{"/limited", true, 2, time.Second}, // waits for Retry-After
+		{"/later", false, 1, 0},            // Retry-After too long
+		{"/gone", false, 1, 0},             // not transient
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			start := time.Now()
+			r := g.retryFetch(context.Background(), ts.URL+tt.path)
+			if r.OK() != tt.ok || r.Attempts != tt.attempts {
+				t.Errorf("%s got ok %t attempts %d want %t %d", tt.path, r.OK(), r.Attempts, tt.ok, tt.attempts)
+			}
+			if elapsed := time.Since(start); elapsed < tt.minTime {
+				t.Errorf("%s took %v, less than %v", tt.path, elapsed, tt.minTime)
+			}
+		})
+	}
+	if got := gone.Load(); got != 1 {
+		t.Errorf("not found url fetched %d times", got)
+	}
+}
+
+// TestRetryFetchCancel checks that a retry wait is abandoned when the
+// context is cancelled
+func TestRetryFetchCancel(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	g := NewGetClient(1, time.Second)
+	g.retry = retryPolicy{retries: 5, backoff: 10 * time.Second, maxBackoff: 10 * time.Second}
+	start := time.Now()
+	r := g.retryFetch(ctx, ts.URL)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("retry took %v after cancellation", elapsed)
+	}
+	if r.OK() || r.Attempts != 1 || ctx.Err() == nil {
+		t.Errorf("unexpected result %+v", r)
+	}
+}
+
+// TestHostLimiter checks that requests to a host are limited in
+// concurrency
+func TestHostLimiter(t *testing.T) {
+	var mu sync.Mutex
+	active, maxActive := 0, 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active++
+		maxActive = max(maxActive, active)
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		fmt.Fprintln(w, "<html>ok</html>")
+	}))
+	defer ts.Close()
+
+	urls := []string{}
+	for i := range 8 {
+		urls = append(urls, fmt.Sprintf("%s/?%d", ts.URL, i))
+	}
+
+	tests := []struct {
+		limit     int
+		interval  time.Duration
+		maxActive int
+		minTime   time.Duration
+	}{
+		{0, 0, 8, 0},
+		{2, 0, 2, 80 * time.Millisecond},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			maxActive = 0
+			g := NewGetClient(8, time.Second)
+			g.hosts = newHostLimiter(tt.limit, tt.interval)
+			start := time.Now()
+			results, err := g.Check(context.Background(), urls)
+			if err != nil || countErrors(results) != 0 {
+				t.Fatalf("unexpected errors %v %d", err, countErrors(results))
+			}
+			if elapsed := time.Since(start); elapsed < tt.minTime {
+				t.Errorf("check took %v, less than %v", elapsed, tt.minTime)
+			}
+			if maxActive > tt.maxActive {
+				t.Errorf("got %d concurrent requests, more than %d", maxActive, tt.maxActive)
+			}
+		})
+	}
+}
+
+// TestHostLimiterInterval checks that the starts of requests to a host
+// are spaced by the interval, using a fake clock
+func TestHostLimiterInterval(t *testing.T) {
+	clock := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	var waits []time.Duration
+	h := newHostLimiter(0, 50*time.Millisecond)
+	h.now = func() time.Time { return clock }
+	h.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return ctx.Err()
+	}
+
+	tests := []struct {
+		url     string
+		advance time.Duration // clock change before the request
+		wait    time.Duration
+	}{
+		{"https://a.example/1", 0, 0},
+		{"https://a.example/2", 0, 50 * time.Millisecond},
+		{"https://A.example/3", 0, 100 * time.Millisecond}, // hosts ignore case
+		{"https://b.example/1", 0, 0},                      // hosts are separate
+		{"https://a.example/4", 120 * time.Millisecond, 30 * time.Millisecond},
+		{"https://a.example/5", time.Second, 0}, // idle host
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			clock = clock.Add(tt.advance)
+			waits = nil
+			release, err := h.acquire(context.Background(), tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			release()
+			if len(waits) != 1 || waits[0] != tt.wait {
+				t.Errorf("%s waited %v want %v", tt.url, waits, tt.wait)
+			}
+		})
+	}
+
+	// a cancelled wait returns the context error
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := h.acquire(ctx, "https://a.example/6"); err == nil {
+		t.Error("expected a cancelled acquire to fail")
+	}
+}
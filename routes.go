@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// routes is the server's routing table. Routes for optional features
+// are only served when the feature is enabled, but the first literal
+// segment of every route path is reserved, so that a short url can
+// never shadow one of the server's own pages, even one added by a later
+// release or enabled later.
+
+var reservedNameError error = errors.New("short url is reserved")
+
+// route is an entry in the routing table
+type route struct {
+	pattern string                           // http.ServeMux pattern
+	serve   func(s *server) bool             // reports if the route is served, or nil if always
+	handler func(s *server) http.HandlerFunc // the route's handler
+}
+
+// routes using go's new 1.22 routes
+var routes = []route{
+	{"GET /{$}", nil, func(s *server) http.HandlerFunc { return s.home }},
+	{"GET /{shortURL}", nil, func(s *server) http.HandlerFunc { return s.redirector }},
+	{"GET /{anyURL...}", nil, func(s *server) http.HandlerFunc { return s.invalid }},
+	{"GET /static/", nil, func(s *server) http.HandlerFunc { return s.staticFiles().ServeHTTP }},
+
+	// link health
+	{"GET /health", monitored, func(s *server) http.HandlerFunc { return s.health }},
+
+	// links api
+	{"GET " + apiPrefix, apiServed, func(s *server) http.HandlerFunc { return s.apiList }},
+	{"POST " + apiPrefix, apiServed, func(s *server) http.HandlerFunc { return s.apiCreate }},
+	{"GET " + apiPrefix + "/{shortURL}", apiServed, func(s *server) http.HandlerFunc { return s.apiGet }},
+	{"PUT " + apiPrefix + "/{shortURL}", apiServed, func(s *server) http.HandlerFunc { return s.apiUpdate }},
+	{"DELETE " + apiPrefix + "/{shortURL}", apiServed, func(s *server) http.HandlerFunc { return s.apiDelete }},
+	{"GET " + apiSessionsPrefix, apiServed, func(s *server) http.HandlerFunc { return s.apiSessions }},
+	{"DELETE " + apiSessionsPrefix + "/{token}", apiServed, func(s *server) http.HandlerFunc { return s.apiEndSessions }},
+
+	// admin console
+	{"GET " + adminPrefix, adminServed, func(s *server) http.HandlerFunc { return s.adminIndex }},
+	{"GET " + adminPrefix + "/login", adminServed, func(s *server) http.HandlerFunc { return s.adminLogin }},
+	{"POST " + adminPrefix + "/login", adminServed, func(s *server) http.HandlerFunc { return s.adminLoginPost }},
+	{"POST " + adminPrefix + "/logout", adminServed, func(s *server) http.HandlerFunc { return s.adminAuth(scopeRead, s.adminLogout) }},
+	{"GET " + adminPrefix + "/links", adminServed, func(s *server) http.HandlerFunc { return s.adminAuth(scopeRead, s.adminLinks) }},
+	{"GET " + adminPrefix + "/new", adminServed, func(s *server) http.HandlerFunc { return s.adminAuth(scopeLinkWrite, s.adminNew) }},
+	{"POST " + adminPrefix + "/new", adminServed, func(s *server) http.HandlerFunc { return s.adminAuth(scopeLinkWrite, s.adminCreate) }},
+	{"GET " + adminPrefix + "/links/{shortURL}", adminServed, func(s *server) http.HandlerFunc { return s.adminAuth(scopeRead, s.adminLink) }},
+	{"POST " + adminPrefix + "/links/{shortURL}", adminServed, func(s *server) http.HandlerFunc { return s.adminAuth(scopeLinkWrite, s.adminUpdate) }},
+	{"POST " + adminPrefix + "/links/{shortURL}/delete", adminServed, func(s *server) http.HandlerFunc { return s.adminAuth(scopeLinkWrite, s.adminDelete) }},
+}
+
+// funcs reporting if optional routes are served
+func monitored(s *server) bool   { return s.monitor != nil }
+func apiServed(s *server) bool   { return s.api }
+func adminServed(s *server) bool { return s.admin }
+
+// reservedNames are the lower case names which short urls may not
+// take, by the route pattern reserving each
+var reservedNames map[string]string
+
+func init() {
+	reservedNames = routeNames(routes)
+}
+
+// routeNames returns the first literal path segment of each route,
+// lower cased, mapped to the pattern of the first route using it
+func routeNames(rs []route) map[string]string {
+	names := map[string]string{}
+	for _, rt := range rs {
+		path := rt.pattern
+		if _, p, ok := strings.Cut(path, " "); ok {
+			path = p
+		}
+		name, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+		if name == "" || strings.HasPrefix(name, "{") {
+			continue
+		}
+		name = strings.ToLower(name)
+		if _, ok := names[name]; !ok {
+			names[name] = rt.pattern
+		}
+	}
+	return names
+}
+
+// reserved returns the sorted reserved names
+func reserved() []string {
+	names := make([]string, 0, len(reservedNames))
+	for n := range reservedNames {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// checkReserved returns a reservedNameError if a short url, ignoring
+// case, is the name of one of the server's routes
+func checkReserved(su string) error {
+	if pattern, ok := reservedNames[strings.ToLower(su)]; ok {
+		return fmt.Errorf("%w: %s is used by the server's %q route", reservedNameError, su, pattern)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouteNames(t *testing.T) {
+	rs := []route{
+		{pattern: "GET /{$}"},
+		{pattern: "GET /{shortURL}"},
+		{pattern: "GET /{anyURL...}"},
+		{pattern: "GET /static/"},
+		{pattern: "GET /Health"},
+		{pattern: "POST /api/v1/links"},
+		{pattern: "DELETE /api/v1/links/{shortURL}"},
+		{pattern: "/login"},
+	}
+	got := fmt.Sprint(routeNames(rs))
+	want := "map[api:POST /api/v1/links health:GET /Health login:/login static:GET /static/]"
+	if got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+}
+
+func TestReservedNames(t *testing.T) {
+	want := []string{"admin", "api", "health", "static"}
+	if got := reserved(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v want %v", got, want)
+	}
+
+	tests := []struct {
+		short    string
+		reserved bool
+	}{
+		{"static", true},
+		{"API", true},
+		{"health", true},
+		{"admin", true},
+		{"preview", false},
+		{"statics", false},
+		{"dbd", false},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			err := checkReserved(tt.short)
+			if got := errors.Is(err, reservedNameError); got != tt.reserved {
+				t.Errorf("%s reserved got %t want %t", tt.short, got, tt.reserved)
+			}
+			if _, err := validateLink(Link{Short: tt.short, Target: "https://x"}); (err != nil) != tt.reserved {
+				t.Errorf("%s validate got %v", tt.short, err)
+			}
+		})
+	}
+}
+
+// TestReservedRoutes checks that every route is served, with all
+// features enabled, and not shadowed by a link
+func TestReservedRoutes(t *testing.T) {
+	tokens, _ := testTokens(t, map[string]string{"admin": "admin"})
+	ns, err := newServer(false, "", "", 0, 0, withAPI(), withAdmin(), withTokens(tokens), withMonitor(time.Hour, 1, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bypass validation to simulate a link added before a route
+	if err := ns.store.Put(context.Background(), Link{Short: "health", Target: "https://health"}); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	ns.mux().ServeHTTP(rec, httptest.NewRequest("GET", "/health", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"links"`) {
+		t.Errorf("health route shadowed: %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestReservedStartup(t *testing.T) {
+	path := tmpLinkFile(t, "abc,https://abc\napi,https://api\n")
+	_, err := newServer(false, "", "", 0, 0, withDataFile(path, time.Second))
+	if !errors.Is(err, reservedNameError) {
+		t.Fatalf("got %v want %v", err, reservedNameError)
+	}
+	if !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("error does not give the line: %v", err)
+	}
+}
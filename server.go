@@ -1,15 +1,20 @@
 package main
 
 import (
+	"context"
 	"embed"
 	_ "embed"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"html"
 	"io/fs"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/handlers"
@@ -33,15 +38,20 @@ var dataFile = "short-urls.csv"
 const defaultPort = "8000"
 const defaultAddr = "0.0.0.0"
 
-// run the server
-func (s *server) serve() error {
+// mux returns the server's routes, from the routing table
+func (s *server) mux() *http.ServeMux {
 	r := http.NewServeMux()
+	for _, rt := range routes {
+		if rt.serve == nil || rt.serve(s) {
+			r.HandleFunc(rt.pattern, rt.handler(s))
+		}
+	}
+	return r
+}
 
-	// routes using go's new 1.22 routes
-	r.HandleFunc("GET /{$}", s.home)
-	r.HandleFunc("GET /{shortURL}", s.redirector)
-	r.HandleFunc("GET /{anyURL...}", s.invalid)
-	r.Handle("GET /static/", s.staticFiles())
+// run the server
+func (s *server) serve() error {
+	r := s.mux()
 
 	// middleware; consider throttling middleware too
 	// gorilla mux middleware "Add" is nice also
@@ -51,7 +61,7 @@ func (s *server) serve() error {
 	recovery := func(handler http.Handler) http.Handler {
 		return handlers.RecoveryHandler()(handler)
 	}
-	chainedHandlers := alice.New(recovery, logging).Then(r)
+	chainedHandlers := alice.New(recovery, logging, s.authenticate).Then(r)
 
 	// configure server options
 	httpServer := &http.Server{
@@ -65,8 +75,30 @@ func (s *server) serve() error {
 		ReadHeaderTimeout: 2 * time.Second,
 	}
 
+	// watch the data file for changes
+	if s.reloader != nil {
+		go s.reloader.watch(s.ctx, s.dataPoll)
+	}
+
+	// check links in the background
+	if s.monitor != nil {
+		go s.monitor.watch(s.ctx)
+	}
+
+	// shut down when the context is cancelled
+	go func() {
+		<-s.ctx.Done()
+		err := httpServer.Shutdown(context.Background())
+		if err != nil {
+			log.Printf("server shutdown error: %v", err)
+		}
+	}()
+
 	log.Printf("Running server on %s", s.FullAddress())
 	err := httpServer.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
 	if err != nil {
 		log.Printf("fatal server error: %v", err)
 	}
@@ -81,8 +113,13 @@ func (s *server) FullAddress() string {
 // vals returns the long urls
 func (s *server) vals() []string {
 	vSlice := []string{}
-	for _, v := range s.urlMap {
-		vSlice = append(vSlice, v)
+	links, err := s.store.List(context.Background())
+	if err != nil {
+		log.Printf("could not list links: %v", err)
+		return vSlice
+	}
+	for _, l := range links {
+		vSlice = append(vSlice, l.Target)
 	}
 	return vSlice
 }
@@ -94,21 +131,46 @@ func (s *server) staticFiles() http.Handler {
 	)
 }
 
-// home is a home page handler
+// home is a home page handler, which summarises link health if the
+// links are monitored
 func (s *server) home(w http.ResponseWriter, r *http.Request) {
-	err := s.homeTpl.Execute(w, struct{ Title string }{"Home"})
+	vars := struct {
+		Title  string
+		Health *healthReport
+	}{Title: "Home"}
+	if s.monitor != nil {
+		report := s.monitor.report()
+		vars.Health = &report
+	}
+	err := s.homeTpl.Execute(w, vars)
 	if err != nil {
 		errorOutput(w, "home", err)
 	}
 }
 
-// invalid is a 404 handler for invalid paths
+// health reports the recent check history of each link as json
+func (s *server) health(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(s.monitor.report())
+	if err != nil {
+		log.Printf("health report error: %v", err)
+	}
+}
+
+// invalid is a 404 handler for invalid paths, other than those to links
+// which append the path after the short url to their targets
 func (s *server) invalid(w http.ResponseWriter, r *http.Request) {
+	if s.pathRedirect(w, r) {
+		return
+	}
 	anyURL := r.PathValue("anyURL")
 	vars := struct {
 		Title, URL  string
 		InvalidPath bool
-	}{"Invalid Path", html.EscapeString(anyURL), true}
+		Suggestions []suggestion
+	}{"Invalid Path", html.EscapeString(anyURL), true, nil}
 	w.WriteHeader(http.StatusNotFound)
 	err := s.notFoundTpl.Execute(w, vars)
 	if err != nil {
@@ -117,31 +179,90 @@ func (s *server) invalid(w http.ResponseWriter, r *http.Request) {
 }
 
 // redirector is the main handler, which falls through to a 404 if no
-// short url key can be found in s.urlMap. Otherwise the user is
-// redirected with a 301 (StatusMovedPermanently) redirect.
+// short url key can be found in s.store. Otherwise the user is
+// redirected with the link's redirect status or, if that is not set, the
+// server default, normally a 301 (StatusMovedPermanently) redirect. A
+// link whose target is failing health checks may be redirected to its
+// fallback instead. Short urls ending in "+" or with a preview=1 query
+// show a preview of the link instead of redirecting. Disabled links are
+// not found. Short urls are normalised by the store, if the server is
+// normalising them. A short url which is not found may be redirected to
+// a confident suggestion. The request's query is passed through to the
+// target if the link's query metadata asks for it.
 func (s *server) redirector(w http.ResponseWriter, r *http.Request) {
-	shortURL := r.PathValue("shortURL")
-	longURL, ok := s.urlMap[shortURL]
-	if ok {
-		http.Redirect(w, r, longURL, http.StatusMovedPermanently)
+	shortURL, preview := wantsPreview(r, r.PathValue("shortURL"))
+	if preview {
+		s.showPreview(w, r, shortURL)
 		return
 	}
-	// short code not found
-	vars := struct {
-		Title, URL  string
-		InvalidPath bool
-	}{"Redirection not found", html.EscapeString(shortURL), false}
-	w.WriteHeader(http.StatusNotFound)
-	err := s.notFoundTpl.Execute(w, vars)
+	link, err := s.store.Lookup(r.Context(), shortURL)
+	if err == nil && linkDisabled(link) {
+		err = linkNotFoundError
+	}
+	if err == nil {
+		s.redirectLink(w, r, link, "")
+		return
+	}
+	if !errors.Is(err, linkNotFoundError) {
+		log.Printf("lookup error for %s: %v", shortURL, err)
+		http.Error(w, "link lookup error", http.StatusInternalServerError)
+		return
+	}
+	sugg := s.suggest(r.Context(), shortURL)
+	if s.redirectSuggestion(w, r, shortURL, sugg) {
+		return
+	}
+	s.notFound(w, shortURL, s.shown(sugg))
+}
+
+// redirectLink redirects to a link's target, or its fallback, with the
+// request's query and the path suffix after the short url passed
+// through to the target as the link's metadata sets
+func (s *server) redirectLink(w http.ResponseWriter, r *http.Request, link Link, suffix string) {
+	target, status, fallback := s.redirectTarget(link)
+	if !fallback {
+		var err error
+		target, err = passthrough(target, link, r, suffix)
+		if err != nil {
+			log.Printf("passthrough error for %s: %v", link.Short, err)
+			http.Error(w, "invalid request", http.StatusBadRequest)
+			return
+		}
+	}
+	s.clicks.add(link.Short)
+	http.Redirect(w, r, target, status)
+}
+
+// pathRedirect redirects a path of more than one segment, such as
+// /docs/getting-started, if the first segment is the short url of an
+// enabled link which appends paths, reporting if it did so
+func (s *server) pathRedirect(w http.ResponseWriter, r *http.Request) bool {
+	code, suffix, ok := strings.Cut(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	if !ok {
+		return false
+	}
+	shortURL, err := url.PathUnescape(code)
 	if err != nil {
-		errorOutput(w, "redirection not found", err)
+		return false
 	}
+	link, err := s.store.Lookup(r.Context(), shortURL)
+	if err != nil {
+		if !errors.Is(err, linkNotFoundError) {
+			log.Printf("lookup error for %s: %v", shortURL, err)
+		}
+		return false
+	}
+	if linkDisabled(link) || !appendsPath(link) {
+		return false
+	}
+	s.redirectLink(w, r, link, suffix)
+	return true
 }
 
 // server holds the main settings for the server
 type server struct {
-	urlMap        map[string]string // the map of short to full urls
-	inDevelopment bool              // use the file system or embedded resources
+	store         Store // the short to full url links
+	inDevelopment bool  // use the file system or embedded resources
 	addr          string
 	port          string
 	templates     fs.FS // templates
@@ -149,12 +270,136 @@ type server struct {
 	data          fs.FS // csv file with short,full urls
 	homeTpl       tpl
 	notFoundTpl   tpl
+	previewTpl    tpl
+	adminLoginTpl tpl
+	adminLinksTpl tpl
+	adminEditTpl  tpl
 	httpTimeout   time.Duration // http client timeout
 	httpWorkers   int
+	defaultStatus int           // redirect status for links without one
+	dataFile      string        // optional csv file watched for changes
+	dataPoll      time.Duration // dataFile polling interval
+	reloader      *csvReloader
+	monitorEvery  time.Duration // link check interval, if monitoring
+	monitorKeep   int           // link check results kept per link
+	monitorProcs  int           // link check workers
+	monitor       *monitor
+	fallbackAfter time.Duration // failing time before using a fallback
+	fallbackURLs  string        // fallback url pattern for all links
+	api           bool          // serve the links api
+	linksMu       sync.Mutex    // serialises changes to links
+	tokens        *tokenSet     // api bearer tokens
+	authFails     *authLimiter  // failed api authentication attempts
+	clicks        *clickCounts  // redirects by short url
+	admin         bool          // serve the admin console
+	sessions      *sessionStore // admin console sessions
+	codeAlphabet  string        // characters of generated short urls, if not the default
+	codeLength    int           // length of generated short urls, if not the default
+	codes         *codeGenerator
+	norm          normaliser      // short url lookup normalisation
+	suggestMax    int             // suggestions on the not found page
+	suggestAt     float64         // suggestion confidence to redirect at, if > 0
+	ctx           context.Context // cancelled to stop the server
+}
+
+// serverOption is a func for setting optional server settings
+type serverOption func(*server)
+
+// withDataFile loads the links from a csv file on the local filesystem
+// instead of the data filesystem, reloading the file when it changes.
+func withDataFile(path string, poll time.Duration) serverOption {
+	return func(s *server) {
+		s.dataFile = path
+		s.dataPoll = poll
+	}
+}
+
+// withDefaultStatus sets the redirect status used for links that do
+// not specify their own.
+func withDefaultStatus(status int) serverOption {
+	return func(s *server) {
+		s.defaultStatus = status
+	}
+}
+
+// withMonitor checks the target of each link every interval while
+// serving, keeping the last history results for each link
+func withMonitor(interval time.Duration, history, workers int) serverOption {
+	return func(s *server) {
+		s.monitorEvery = interval
+		s.monitorKeep = history
+		s.monitorProcs = workers
+	}
+}
+
+// withFallback redirects links whose targets have been failing health
+// checks for longer than after to their fallback urls, made from pattern
+// for links without a fallback of their own
+func withFallback(after time.Duration, pattern string) serverOption {
+	return func(s *server) {
+		s.fallbackAfter = after
+		s.fallbackURLs = pattern
+	}
+}
+
+// withAPI serves the json api for managing links
+func withAPI() serverOption {
+	return func(s *server) {
+		s.api = true
+	}
+}
+
+// withTokens authenticates api requests with the given tokens
+func withTokens(tokens *tokenSet) serverOption {
+	return func(s *server) {
+		s.tokens = tokens
+	}
+}
+
+// withAdmin serves the admin console, logging in with api tokens
+func withAdmin() serverOption {
+	return func(s *server) {
+		s.admin = true
+	}
+}
+
+// withCodes sets the alphabet and length of the short urls generated
+// for links created without one
+func withCodes(alphabet string, length int) serverOption {
+	return func(s *server) {
+		s.codeAlphabet = alphabet
+		s.codeLength = length
+	}
+}
+
+// withNormalise looks up short urls which do not match a link exactly
+// by their normalised form
+func withNormalise(n normaliser) serverOption {
+	return func(s *server) {
+		s.norm = n
+	}
+}
+
+// withSuggestions shows up to n suggestions for short urls which are
+// not found, redirecting to the closest if its confidence is at least
+// redirect, if that is more than 0
+func withSuggestions(n int, redirect float64) serverOption {
+	return func(s *server) {
+		s.suggestMax = n
+		s.suggestAt = redirect
+	}
+}
+
+// withContext stops the server, and any checks it runs, when ctx is
+// cancelled
+func withContext(ctx context.Context) serverOption {
+	return func(s *server) {
+		s.ctx = ctx
+	}
 }
 
 // newServer creates a new server and attaches various resources
-func newServer(dev bool, addr, port string, timeout time.Duration, workers int) (*server, error) {
+func newServer(dev bool, addr, port string, timeout time.Duration, workers int, opts ...serverOption) (*server, error) {
 	var err error
 	if addr == "" {
 		addr = defaultAddr
@@ -168,6 +413,37 @@ func newServer(dev bool, addr, port string, timeout time.Duration, workers int)
 		port:          port,
 		httpTimeout:   timeout,
 		httpWorkers:   workers,
+		defaultStatus: http.StatusMovedPermanently,
+		fallbackAfter: defaultFallbackAfter,
+		authFails:     newAuthLimiter(maxAuthFailures, authFailureWindow),
+		clicks:        newClickCounts(),
+		ctx:           context.Background(),
+		sessions:      newSessionStore(defaultSessionTTL),
+		suggestMax:    defaultSuggestions,
+	}
+	for _, o := range opts {
+		o(&s)
+	}
+	if !validStatus(s.defaultStatus) {
+		return &s, fmt.Errorf("invalid default redirect status %d", s.defaultStatus)
+	}
+	if s.api && s.tokens == nil {
+		return &s, fmt.Errorf("the links api needs tokens, from a tokens file or %s", tokensEnv)
+	}
+	if s.suggestAt < 0 || s.suggestAt > 1 {
+		return &s, fmt.Errorf("suggestion redirect confidence %v is not between 0 and 1", s.suggestAt)
+	}
+	if s.admin && s.tokens == nil {
+		return &s, fmt.Errorf("the admin console needs tokens, from a tokens file or %s", tokensEnv)
+	}
+	s.codes, err = newCodeGenerator(s.codeAlphabet, s.codeLength)
+	if err != nil {
+		return &s, err
+	}
+	if s.fallbackURLs != "" {
+		if err := validFallbackPattern(s.fallbackURLs); err != nil {
+			return &s, err
+		}
 	}
 
 	// attach file systems
@@ -193,22 +469,60 @@ func newServer(dev bool, addr, port string, timeout time.Duration, workers int)
 	if err != nil {
 		return &s, fmt.Errorf("could not load 404 template: %v", err)
 	}
-
-	// load urls
-	dataFile, err := s.data.Open(dataFile)
+	s.previewTpl, err = TplParse(s.inDevelopment, s.templates, "preview.html")
 	if err != nil {
-		return &s, fmt.Errorf("could not open data file: %v", err)
+		return &s, fmt.Errorf("could not load preview template: %v", err)
 	}
-	s.urlMap, err = urls(dataFile)
-	if err != nil {
-		return &s, fmt.Errorf("could not load urls: %v", err)
+	if s.admin {
+		s.adminLoginTpl, err = TplParse(s.inDevelopment, s.templates, "admin/login.html")
+		if err != nil {
+			return &s, fmt.Errorf("could not load admin login template: %v", err)
+		}
+		s.adminLinksTpl, err = TplParse(s.inDevelopment, s.templates, "admin/links.html")
+		if err != nil {
+			return &s, fmt.Errorf("could not load admin links template: %v", err)
+		}
+		s.adminEditTpl, err = TplParse(s.inDevelopment, s.templates, "admin/edit.html")
+		if err != nil {
+			return &s, fmt.Errorf("could not load admin link template: %v", err)
+		}
+	}
+
+	// load urls, either from a watched file or the data filesystem
+	if s.dataFile != "" {
+		s.reloader, err = newCSVReloader(s.dataFile, s.norm)
+		if err != nil {
+			return &s, err
+		}
+		s.store = s.reloader.store
+	} else {
+		dataFile, err := s.data.Open(dataFile)
+		if err != nil {
+			return &s, fmt.Errorf("could not open data file: %v", err)
+		}
+		s.store, err = newCSVStore(dataFile, s.norm)
+		if err != nil {
+			return &s, fmt.Errorf("could not load urls:\n%w", err)
+		}
+	}
+
+	if s.monitorEvery > 0 {
+		s.monitor = newMonitor(s.store, s.monitorEvery, s.monitorKeep, s.monitorProcs, s.httpTimeout)
 	}
 
 	// verify urls if in development
 	if s.inDevelopment {
 		g := NewGetClient(s.httpWorkers, s.httpTimeout)
-		count, errCount := g.Check(s.vals())
-		fmt.Printf("url check reported %d errors in %d url checks\n", errCount, count)
+		links, err := s.store.List(s.ctx)
+		if err != nil {
+			return &s, fmt.Errorf("could not list links: %v", err)
+		}
+		g.allowContentTypes(links)
+		results, _ := g.Check(s.ctx, s.vals())
+		_, err = writeText(os.Stdout, results)
+		if err != nil {
+			return &s, fmt.Errorf("could not report url check: %v", err)
+		}
 	}
 
 	return &s, nil
@@ -2,10 +2,12 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
@@ -47,6 +49,7 @@ func TestServerDevelopment(t *testing.T) {
 		{"file ok", "GET", "http://127.0.0.1:8765/static/styles.css", 200, "margin"},
 		{"file notok", "GET", "http://127.0.0.1:8765/static/nonsense", 404, "not"},
 		{"redirect", "GET", "http://127.0.0.1:8765/dbd", 301, ""},
+		{"preview", "GET", "http://127.0.0.1:8765/dbd+", 200, "Continue"},
 	}
 
 	for _, tt := range tests {
@@ -136,3 +139,42 @@ func TestErrorOutput(t *testing.T) {
 		t.Errorf("got %s != want %s", got, want)
 	}
 }
+
+func TestServerDefaultStatus(t *testing.T) {
+	_, err := newServer(false, "", "", 0, 0, withDefaultStatus(http.StatusOK))
+	if err == nil {
+		t.Error("expected error for invalid default status")
+	}
+
+	ns, err := newServer(false, "", "", 0, 0, withDefaultStatus(http.StatusTemporaryRedirect))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{shortURL}", ns.redirector)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest("GET", "/dbd", nil))
+	if got, want := rec.Code, http.StatusTemporaryRedirect; got != want {
+		t.Errorf("status got %d want %d", got, want)
+	}
+}
+
+// TestServerContext checks that cancelling the server's context stops
+// the development link check and the server
+func TestServerContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	path := tmpLinkFile(t, "abc,https://abc.invalid\n")
+
+	start := time.Now()
+	ns, err := newServer(true, "127.0.0.1", "0", 10*time.Second, 1, withDataFile(path, 0), withContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("development check took %v after cancellation", elapsed)
+	}
+	if err := ns.serve(); err != nil {
+		t.Errorf("unexpected serve error %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// session holds the logged in sessions of the admin console. A session
+// is started by logging in with an api token, and carries that token's
+// scopes. Sessions are held in memory, so are lost on restart. Each
+// session has a csrf value which must be posted with every form.
+
+// admin cookies
+const (
+	sessionCookie = "admin_session" // session id
+	loginCookie   = "admin_login"   // csrf value for the login form
+)
+
+// defaultSessionTTL is how long an admin session lasts
+const defaultSessionTTL = 8 * time.Hour
+
+// adminSession is a logged in admin console session
+type adminSession struct {
+	token   apiToken
+	csrf    string
+	expires time.Time
+}
+
+// sessionStore is a concurrency safe set of sessions by session id
+type sessionStore struct {
+	ttl      time.Duration
+	mu       sync.Mutex
+	sessions map[string]adminSession
+}
+
+// newSessionStore makes a sessionStore with sessions lasting ttl
+func newSessionStore(ttl time.Duration) *sessionStore {
+	return &sessionStore{ttl: ttl, sessions: map[string]adminSession{}}
+}
+
+// create starts a session for a token, returning its id
+func (ss *sessionStore) create(t apiToken, now time.Time) (string, adminSession, error) {
+	id, err := newToken()
+	if err != nil {
+		return "", adminSession{}, err
+	}
+	csrf, err := newToken()
+	if err != nil {
+		return "", adminSession{}, err
+	}
+	sess := adminSession{token: t, csrf: csrf, expires: now.Add(ss.ttl)}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	for k, v := range ss.sessions {
+		if !now.Before(v.expires) {
+			delete(ss.sessions, k)
+		}
+	}
+	ss.sessions[id] = sess
+	return id, sess, nil
+}
+
+// get returns the unexpired session with id
+func (ss *sessionStore) get(id string, now time.Time) (adminSession, bool) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	sess, ok := ss.sessions[id]
+	if !ok {
+		return sess, false
+	}
+	if !now.Before(sess.expires) {
+		delete(ss.sessions, id)
+		return sess, false
+	}
+	return sess, true
+}
+
+// remove ends the session with id
+func (ss *sessionStore) remove(id string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	delete(ss.sessions, id)
+}
+
+// sessionInfo describes a session, without its secrets
+type sessionInfo struct {
+	Token   string    `json:"token"`
+	Scopes  []string  `json:"scopes"`
+	Expires time.Time `json:"expires"`
+}
+
+// list returns the unexpired sessions, soonest to expire first
+func (ss *sessionStore) list(now time.Time) []sessionInfo {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	infos := []sessionInfo{}
+	for _, sess := range ss.sessions {
+		if now.Before(sess.expires) {
+			infos = append(infos, sessionInfo{sess.token.Name, sess.token.Scopes, sess.expires})
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if !infos[i].Expires.Equal(infos[j].Expires) {
+			return infos[i].Expires.Before(infos[j].Expires)
+		}
+		return infos[i].Token < infos[j].Token
+	})
+	return infos
+}
+
+// removeToken ends the sessions started with the named token, returning
+// the number ended
+func (ss *sessionStore) removeToken(name string) int {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	n := 0
+	for id, sess := range ss.sessions {
+		if sess.token.Name == name {
+			delete(ss.sessions, id)
+			n++
+		}
+	}
+	return n
+}
+
+// sameSecret reports if two secrets match, in constant time
+func sameSecret(a, b string) bool {
+	return a != "" && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// setAdminCookie sets an admin cookie, which expires when the browser
+// closes if maxAge is 0, or is removed if maxAge is negative
+func setAdminCookie(w http.ResponseWriter, r *http.Request, name, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     adminPrefix,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSessionStore(t *testing.T) {
+	ss := newSessionStore(time.Hour)
+	now := time.Now()
+	id, sess, err := ss.create(apiToken{Name: "ci"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == "" || sess.csrf == "" || id == sess.csrf {
+		t.Fatalf("unexpected session %s %+v", id, sess)
+	}
+	if got, ok := ss.get(id, now.Add(time.Minute)); !ok || got.token.Name != "ci" {
+		t.Errorf("session not found: %+v", got)
+	}
+	if _, ok := ss.get("other", now); ok {
+		t.Error("unknown session found")
+	}
+	if _, ok := ss.get(id, now.Add(time.Hour)); ok {
+		t.Error("expired session found")
+	}
+	if _, ok := ss.sessions[id]; ok {
+		t.Error("expired session not removed")
+	}
+
+	id, _, _ = ss.create(apiToken{Name: "ci"}, now)
+	ss.remove(id)
+	if _, ok := ss.get(id, now); ok {
+		t.Error("removed session found")
+	}
+}
+
+func TestSessionStoreTokens(t *testing.T) {
+	ss := newSessionStore(time.Hour)
+	now := time.Now()
+	for _, name := range []string{"ci", "ops", "ci"} {
+		if _, _, err := ss.create(apiToken{Name: name, Scopes: []string{scopeRead}}, now); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(time.Minute)
+	}
+	got := []string{}
+	for _, info := range ss.list(now) {
+		got = append(got, info.Token)
+	}
+	if want := "[ci ops ci]"; fmt.Sprint(got) != want {
+		t.Errorf("sessions got %v want %s", got, want)
+	}
+	if n := ss.removeToken("ci"); n != 2 {
+		t.Errorf("removed %d sessions want 2", n)
+	}
+	if n := len(ss.list(now)); n != 1 {
+		t.Errorf("got %d sessions want 1", n)
+	}
+	if n := len(ss.list(now.Add(time.Hour))); n != 0 {
+		t.Errorf("got %d expired sessions want 0", n)
+	}
+}
+
+func TestSameSecret(t *testing.T) {
+	if !sameSecret("abc", "abc") || sameSecret("abc", "abd") || sameSecret("", "") || sameSecret("abc", "") {
+		t.Error("unexpected secret comparison")
+	}
+}
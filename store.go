@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// store provides an abstraction over the backend holding the short url
+// to long url links, allowing the http handlers to be independent of
+// how and where the links are kept.
+
+var linkNotFoundError error = errors.New("link not found")
+
+// Link is a short url and the target url to which it redirects. A zero
+// Status means the server's default redirect status is used. Meta holds
+// optional named metadata, such as an owner or notes.
+type Link struct {
+	Short  string            `json:"short"`
+	Target string            `json:"target"`
+	Status int               `json:"status,omitempty"`
+	Meta   map[string]string `json:"meta,omitempty"`
+	line   int               // line in the source csv file, if known
+}
+
+// Store is the interface implemented by link storage backends.
+// Lookup returns linkNotFoundError if the short url is not known.
+type Store interface {
+	Lookup(ctx context.Context, shortURL string) (Link, error)
+	List(ctx context.Context) ([]Link, error)
+	Put(ctx context.Context, link Link) error
+	Delete(ctx context.Context, shortURL string) error
+}
+
+// snapshot is an immutable set of links. A snapshot must not be
+// modified once it has been published to a memStore.
+type snapshot struct {
+	links map[string]Link
+	keys  map[string]string // normalised short urls, if normalising
+}
+
+// newSnapshot makes a snapshot from a map of short urls to links
+func newSnapshot(m map[string]Link) *snapshot {
+	snap := snapshot{links: make(map[string]Link, len(m))}
+	for k, v := range m {
+		snap.links[k] = v
+	}
+	return &snap
+}
+
+// clone returns a copy of the snapshot which may be modified before
+// publication
+func (snap *snapshot) clone() *snapshot {
+	c := snapshot{links: make(map[string]Link, len(snap.links))}
+	for k, v := range snap.links {
+		c.links[k] = v
+	}
+	return &c
+}
+
+// memStore is an in-memory Store, used directly in testing and as the
+// basis of other backends. Readers load the current snapshot without
+// locking; writers build a new snapshot and publish it atomically.
+type memStore struct {
+	mu   sync.Mutex // serialises writers
+	snap atomic.Pointer[snapshot]
+	norm normaliser // lookup normalisation, if any
+}
+
+// newMemStore returns a memStore initialised from a map of short urls
+// to links
+func newMemStore(m map[string]Link) *memStore {
+	s := memStore{}
+	s.swap(newSnapshot(m))
+	return &s
+}
+
+// newNormalisedStore returns a memStore which looks up short urls by
+// their normalised form if there is no exact match
+func newNormalisedStore(m map[string]Link, norm normaliser) *memStore {
+	s := memStore{norm: norm}
+	s.swap(newSnapshot(m))
+	return &s
+}
+
+// swap indexes a snapshot by normalised short url, if normalising, and
+// publishes it
+func (s *memStore) swap(snap *snapshot) {
+	snap.keys = s.norm.index(snap.links)
+	s.snap.Store(snap)
+}
+
+// Lookup returns the link for shortURL or, failing that, the link with
+// the same normalised short url
+func (s *memStore) Lookup(ctx context.Context, shortURL string) (Link, error) {
+	snap := s.snap.Load()
+	l, ok := snap.links[shortURL]
+	if !ok && snap.keys != nil {
+		l, ok = snap.links[snap.keys[s.norm.key(shortURL)]]
+	}
+	if !ok {
+		return l, linkNotFoundError
+	}
+	return l, nil
+}
+
+// List returns all links, sorted by short url
+func (s *memStore) List(ctx context.Context) ([]Link, error) {
+	snap := s.snap.Load()
+	links := make([]Link, 0, len(snap.links))
+	for _, l := range snap.links {
+		links = append(links, l)
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].Short < links[j].Short })
+	return links, nil
+}
+
+// Put adds or replaces a link
+func (s *memStore) Put(ctx context.Context, link Link) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := s.snap.Load().clone()
+	snap.links[link.Short] = link
+	s.swap(snap)
+	return nil
+}
+
+// Delete removes a link, returning linkNotFoundError if it does not
+// exist
+func (s *memStore) Delete(ctx context.Context, shortURL string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.snap.Load().links[shortURL]; !ok {
+		return linkNotFoundError
+	}
+	snap := s.snap.Load().clone()
+	delete(snap.links, shortURL)
+	s.swap(snap)
+	return nil
+}
+
+// csvStore is a Store loaded from a csv file in short,long url format
+// using urls. Changes made through Put and Delete are held in memory
+// and, if path is set, written back to the file at path.
+type csvStore struct {
+	*memStore
+	path string // file written on Put and Delete, if set
+}
+
+// newCSVStore loads a csvStore from a csv reader, reporting all
+// validation error, and short urls which are the same once normalised
+// by norm, as csvErrors
+func newCSVStore(r io.Reader, norm normaliser) (*csvStore, error) {
+	m, err := validateURLs(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := norm.check(m); err != nil {
+		return nil, err
+	}
+	return &csvStore{memStore: newNormalisedStore(m, norm)}, nil
+}
+
+// replace publishes a new snapshot of the map of short urls to links in
+// m, replacing the complete set of links held by the store
+func (c *csvStore) replace(m map[string]Link) {
+	snap := newSnapshot(m)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.swap(snap)
+}
+
+// Put adds or replaces a link, keeping the position in the file of a
+// replaced link
+func (c *csvStore) Put(ctx context.Context, link Link) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snap := c.snap.Load().clone()
+	link.line = snap.links[link.Short].line
+	snap.links[link.Short] = link
+	return c.publish(snap)
+}
+
+// Delete removes a link, returning linkNotFoundError if it does not
+// exist
+func (c *csvStore) Delete(ctx context.Context, shortURL string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.snap.Load().links[shortURL]; !ok {
+		return linkNotFoundError
+	}
+	snap := c.snap.Load().clone()
+	delete(snap.links, shortURL)
+	return c.publish(snap)
+}
+
+// publish writes a changed snapshot to the file, if there is one, and
+// then publishes it. The caller must hold the writer lock.
+func (c *csvStore) publish(snap *snapshot) error {
+	if c.path != "" {
+		err := writeURLFile(c.path, sortedLinks(snap.links))
+		if err != nil {
+			return fmt.Errorf("could not save links: %w", err)
+		}
+	}
+	c.swap(snap)
+	return nil
+}
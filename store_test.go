@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLinks makes a map of links from a map of short to long urls
+func testLinks(m map[string]string) map[string]Link {
+	links := map[string]Link{}
+	for k, v := range m {
+		links[k] = Link{Short: k, Target: v}
+	}
+	return links
+}
+
+func TestMemStore(t *testing.T) {
+	ctx := context.Background()
+	s := newMemStore(testLinks(map[string]string{"abc": "https://abc", "def": "https://def"}))
+
+	l, err := s.Lookup(ctx, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := l.Target, "https://abc"; got != want {
+		t.Errorf("lookup got %s want %s", got, want)
+	}
+	if _, err := s.Lookup(ctx, "xyz"); !errors.Is(err, linkNotFoundError) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+
+	if err := s.Put(ctx, Link{Short: "aaa", Target: "https://aaa"}); err != nil {
+		t.Fatal(err)
+	}
+	links, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(links), 3; got != want {
+		t.Fatalf("list got %d links want %d", got, want)
+	}
+	if got, want := links[0].Short, "aaa"; got != want {
+		t.Errorf("first sorted link got %s want %s", got, want)
+	}
+
+	if err := s.Delete(ctx, "abc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "abc"); !errors.Is(err, linkNotFoundError) {
+		t.Errorf("expected not found error on second delete, got %v", err)
+	}
+}
+
+func TestCSVStore(t *testing.T) {
+	s, err := newCSVStore(strings.NewReader("abc,https://def\nghi,https://xyz\n"), normaliser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	links, err := s.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := len(links), 2; got != want {
+		t.Errorf("got %d links want %d", got, want)
+	}
+
+	_, err = newCSVStore(strings.NewReader("abc,def"), normaliser{})
+	if err == nil {
+		t.Error("expected csv error")
+	}
+}
+
+// TestRedirectorWithStore checks the redirector against an in-memory
+// store
+func TestRedirectorWithStore(t *testing.T) {
+	ns, err := newServer(false, "", "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	links := testLinks(map[string]string{"fake": "https://example.com/fake"})
+	links["temp"] = Link{Short: "temp", Target: "https://example.com/temp", Status: http.StatusFound}
+	ns.store = newMemStore(links)
+
+	tests := []struct {
+		path     string
+		status   int
+		location string
+	}{
+		{"/fake", http.StatusMovedPermanently, "https://example.com/fake"},
+		{"/temp", http.StatusFound, "https://example.com/temp"},
+		{"/dbd", http.StatusNotFound, ""},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{shortURL}", ns.redirector)
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+			if got, want := rec.Code, tt.status; got != want {
+				t.Errorf("status got %d want %d", got, want)
+			}
+			if got, want := rec.Header().Get("Location"), tt.location; got != want {
+				t.Errorf("location got %s want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestNormalisedLookup(t *testing.T) {
+	links := testLinks(map[string]string{"dbd": "https://dbd", "Spring-Sale": "https://spring"})
+	n, err := newNormaliser([]string{"case", "dashes", "punctuation"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newNormalisedStore(links, n)
+	ctx := context.Background()
+
+	tests := []struct {
+		short, found string
+	}{
+		{"dbd", "dbd"},
+		{"DBD", "dbd"},
+		{"dbd.", "dbd"},
+		{"Dbd),", "dbd"},
+		{"spring_sale", "Spring-Sale"},
+		{"Spring-Sale", "Spring-Sale"},
+		{"SPRING-SALE!", "Spring-Sale"},
+		{"springsale", ""},
+		{".dbd", ""},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			l, err := s.Lookup(ctx, tt.short)
+			if tt.found == "" {
+				if !errors.Is(err, linkNotFoundError) {
+					t.Errorf("%s got %v want not found", tt.short, err)
+				}
+				return
+			}
+			if err != nil || l.Short != tt.found {
+				t.Errorf("%s got %s %v want %s", tt.short, l.Short, err, tt.found)
+			}
+		})
+	}
+
+	// the index follows changes
+	if err := s.Put(ctx, Link{Short: "New", Target: "https://new"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "dbd"); err != nil {
+		t.Fatal(err)
+	}
+	if l, err := s.Lookup(ctx, "new"); err != nil || l.Short != "New" {
+		t.Errorf("new link got %s %v", l.Short, err)
+	}
+	if _, err := s.Lookup(ctx, "DBD"); !errors.Is(err, linkNotFoundError) {
+		t.Errorf("deleted link got %v", err)
+	}
+
+	// exact lookups only without normalisation
+	if _, err := newMemStore(links).Lookup(ctx, "DBD"); !errors.Is(err, linkNotFoundError) {
+		t.Errorf("got %v want not found", err)
+	}
+}
+
+func TestNormalisedRedirects(t *testing.T) {
+	path := tmpLinkFile(t, "dbd,https://dbd\nspring-sale,https://spring\n")
+	n, _ := newNormaliser([]string{"case", "dashes", "punctuation"})
+	ns, err := newServer(false, "", "", 0, 0, withDataFile(path, time.Second), withNormalise(n))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct{ path, location string }{
+		{"/DBD", "https://dbd"},
+		{"/dbd.", "https://dbd"},
+		{"/Spring_Sale", "https://spring"},
+	} {
+		rec := httptest.NewRecorder()
+		ns.mux().ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != tt.location {
+			t.Errorf("%s got %d %s want %s", tt.path, rec.Code, rec.Header().Get("Location"), tt.location)
+		}
+	}
+	if got := ns.clicks.get("dbd"); got != 2 {
+		t.Errorf("got %d clicks want 2", got)
+	}
+
+	// links which are the same once normalised are not loaded
+	path = tmpLinkFile(t, "dbd,https://dbd\nDBD,https://other\n")
+	_, err = newServer(false, "", "", 0, 0, withDataFile(path, time.Second), withNormalise(n))
+	if err == nil || !strings.Contains(err.Error(), "line 2: short url DBD is the same as dbd at line 1 once normalised") {
+		t.Errorf("unexpected error %v", err)
+	}
+	if _, err := newServer(false, "", "", 0, 0, withDataFile(path, time.Second)); err != nil {
+		t.Errorf("unexpected error without normalisation: %v", err)
+	}
+}
+
+// TestStoreConcurrentSwaps hammers lookups and lists while snapshots
+// are swapped by reloads and writes. Run with the race detector.
+func TestStoreConcurrentSwaps(t *testing.T) {
+	ctx := context.Background()
+	s := &csvStore{memStore: newMemStore(testLinks(map[string]string{"abc": "https://abc"}))}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				l, err := s.Lookup(ctx, "abc")
+				if err != nil {
+					t.Errorf("abc lookup error %v", err)
+					return
+				}
+				if !strings.HasPrefix(l.Target, "https://abc") {
+					t.Errorf("unexpected target %s", l.Target)
+					return
+				}
+				if _, err := s.List(ctx); err != nil {
+					t.Errorf("list error %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	for i := range 200 {
+		target := fmt.Sprintf("https://abc/%d", i)
+		if i%2 == 0 {
+			s.replace(testLinks(map[string]string{"abc": target, "def": "https://def"}))
+		} else {
+			_ = s.Put(ctx, Link{Short: "abc", Target: target})
+			_ = s.Delete(ctx, "def")
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestCSVStoreWriteThrough(t *testing.T) {
+	ctx := context.Background()
+	path := tmpLinkFile(t, "def,https://def\nabc,https://abc\n")
+	r, err := newCSVReloader(path, normaliser{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := r.store
+
+	if err := s.Put(ctx, Link{Short: "abc", Target: "https://abc/new"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(ctx, Link{Short: "aaa", Target: "https://aaa"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "def"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := readLinkFile(t, path), "abc,https://abc/new\naaa,https://aaa\n"; got != want {
+		t.Errorf("file got %q want %q", got, want)
+	}
+
+	// a failed save leaves the store unchanged
+	s.path = filepath.Join(t.TempDir(), "missing", "links.csv")
+	if err := s.Put(ctx, Link{Short: "xyz", Target: "https://xyz"}); err == nil {
+		t.Error("expected save error")
+	}
+	if _, err := s.Lookup(ctx, "xyz"); !errors.Is(err, linkNotFoundError) {
+		t.Errorf("unsaved link was stored: %v", err)
+	}
+}
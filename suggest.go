@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// suggest finds the links closest to a short url which was not found,
+// by edit distance, so that the not found page can ask "did you mean"
+// with links to them. If the closest link is a confident enough match,
+// and no other link is as close, the server may redirect to it instead.
+// Private and disabled links are never suggested.
+
+// metaPrivate is the link metadata column which, if true, keeps a link
+// out of suggestions
+const metaPrivate = "private"
+
+// defaultSuggestions is the number of suggestions shown
+const defaultSuggestions = 3
+
+// maxSuggestDistance is the largest edit distance of a suggestion
+const maxSuggestDistance = 3
+
+// suggestion is a link suggested for a short url
+type suggestion struct {
+	Link
+	Distance   int     // edits from the short url
+	Confidence float64 // 1 less distance as a fraction of length
+}
+
+// editDistance returns the optimal string alignment distance between a
+// and b: the number of insertions, deletions, substitutions and
+// transpositions of adjacent characters needed to make one the other
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	// rows i-2, i-1 and i of the distance matrix
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
+
+// suggestDistance is the largest edit distance of a suggestion for a
+// short url, allowing one edit for every three characters
+func suggestDistance(su string) int {
+	return max(1, min(maxSuggestDistance, len([]rune(su))/3))
+}
+
+// suggestions returns up to n links closest to su, ignoring case,
+// ordered by distance and then short url
+func suggestions(links []Link, su string, n int) []suggestion {
+	if n <= 0 || su == "" {
+		return nil
+	}
+	key := strings.ToLower(su)
+	limit := suggestDistance(key)
+	var found []suggestion
+	for _, l := range links {
+		if metaFlag(l, metaPrivate) || linkDisabled(l) {
+			continue
+		}
+		short := strings.ToLower(l.Short)
+		if diff := len(short) - len(key); diff > limit || -diff > limit {
+			continue
+		}
+		d := editDistance(key, short)
+		if d > limit {
+			continue
+		}
+		found = append(found, suggestion{
+			Link:       l,
+			Distance:   d,
+			Confidence: 1 - float64(d)/float64(max(len([]rune(key)), len([]rune(short)))),
+		})
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].Distance != found[j].Distance {
+			return found[i].Distance < found[j].Distance
+		}
+		return found[i].Short < found[j].Short
+	})
+	if len(found) > n {
+		found = found[:n]
+	}
+	return found
+}
+
+// suggest returns the links which may be suggested for a short url
+// which was not found. If the server redirects to confident suggestions
+// at least two are returned, so that ties are found whatever the number
+// shown.
+func (s *server) suggest(ctx context.Context, su string) []suggestion {
+	n := s.suggestMax
+	if s.suggestAt > 0 {
+		n = max(n, 2)
+	}
+	if n <= 0 {
+		return nil
+	}
+	links, err := s.store.List(ctx)
+	if err != nil {
+		log.Printf("could not list links for suggestions: %v", err)
+		return nil
+	}
+	return suggestions(links, su, n)
+}
+
+// shown returns the suggestions to show on the not found page
+func (s *server) shown(sugg []suggestion) []suggestion {
+	if len(sugg) > s.suggestMax {
+		return sugg[:max(s.suggestMax, 0)]
+	}
+	return sugg
+}
+
+// confident returns the suggestion to redirect to, if the closest is at
+// least as confident as the server's threshold and no other is as close
+func (s *server) confident(sugg []suggestion) (suggestion, bool) {
+	if s.suggestAt <= 0 || len(sugg) == 0 {
+		return suggestion{}, false
+	}
+	best := sugg[0]
+	if best.Confidence < s.suggestAt {
+		return best, false
+	}
+	if len(sugg) > 1 && sugg[1].Distance == best.Distance {
+		return best, false
+	}
+	return best, true
+}
+
+// redirectSuggestion redirects a short url which was not found to a
+// confident suggestion, if there is one, reporting if it did so. The
+// redirect is temporary, as it is a guess.
+func (s *server) redirectSuggestion(w http.ResponseWriter, r *http.Request, su string, sugg []suggestion) bool {
+	best, ok := s.confident(sugg)
+	if !ok {
+		return false
+	}
+	target, _, _ := s.redirectTarget(best.Link)
+	log.Printf("redirecting %s to suggestion %s (confidence %.2f)", su, best.Short, best.Confidence)
+	s.clicks.add(best.Short)
+	http.Redirect(w, r, target, http.StatusFound)
+	return true
+}
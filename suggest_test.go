@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		d    int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"", "abc", 3},
+		{"abc", "abc", 0},
+		{"abc", "abd", 1},   // substitution
+		{"abc", "abcd", 1},  // insertion
+		{"abcd", "acd", 1},  // deletion
+		{"abcd", "abdc", 1}, // transposition
+		{"ca", "abc", 3},
+		{"kitten", "sitting", 3},
+		{"spring-sale", "sprnig-sale", 1},
+		{"héllo", "hello", 1},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			if got := editDistance(tt.a, tt.b); got != tt.d {
+				t.Errorf("%s %s got %d want %d", tt.a, tt.b, got, tt.d)
+			}
+			if got := editDistance(tt.b, tt.a); got != tt.d {
+				t.Errorf("%s %s reversed got %d want %d", tt.b, tt.a, got, tt.d)
+			}
+		})
+	}
+}
+
+func TestSuggestions(t *testing.T) {
+	links := []Link{
+		{Short: "dbd", Target: "https://dbd"},
+		{Short: "dbe", Target: "https://dbe"},
+		{Short: "spring-sale", Target: "https://spring"},
+		{Short: "spring-sail", Target: "https://sail", Meta: map[string]string{metaPrivate: "true"}},
+		{Short: "summer-sale", Target: "https://summer", Meta: map[string]string{metaDisabled: "yes"}},
+		{Short: "autumn", Target: "https://autumn"},
+	}
+	tests := []struct {
+		short string
+		n     int
+		want  string
+	}{
+		{"dbx", 3, "dbd dbe"},
+		{"DBD", 3, "dbd dbe"},
+		{"dbx", 1, "dbd"},
+		{"dbx", 0, ""},
+		{"xyz", 3, ""},
+		{"sprnig-sale", 3, "spring-sale"}, // not the private spring-sail
+		{"sumer-sale", 3, ""},             // disabled
+		{"autum", 3, "autumn"},
+		{"aut", 3, ""}, // too far
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			got := []string{}
+			for _, s := range suggestions(links, tt.short, tt.n) {
+				got = append(got, s.Short)
+			}
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("%s got %v want %s", tt.short, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSuggestRedirect(t *testing.T) {
+	links := testLinks(map[string]string{
+		"dbd":         "https://dbd",
+		"spring-sale": "https://spring",
+		"abc1":        "https://abc1",
+		"abc2":        "https://abc2",
+	})
+	links["secret"] = Link{Short: "secret", Target: "https://secret", Meta: map[string]string{metaPrivate: "1"}}
+
+	tests := []struct {
+		threshold float64
+		path      string
+		status    int
+		contains  string
+	}{
+		{0, "/sprnig-sale", 404, `<a href="/spring-sale">`},
+		{0.8, "/sprnig-sale", 302, ""},
+		{0.95, "/sprnig-sale", 404, "Did you mean"},
+		{0.5, "/abc", 404, `<a href="/abc1">`}, // ties are not redirected
+		{0.5, "/secrt", 404, "was not found"},  // private
+		{0.5, "/secrt", 404, "!Did you mean"},
+		{0, "/dbx+", 404, `<a href="/dbd">`},
+		{0.5, "/nothing-like-it", 404, "!Did you mean"},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			ns, err := newServer(false, "", "", 0, 0, withSuggestions(defaultSuggestions, tt.threshold))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ns.store = newMemStore(links)
+			rec := httptest.NewRecorder()
+			ns.mux().ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+			if rec.Code != tt.status {
+				t.Errorf("%s status got %d want %d", tt.path, rec.Code, tt.status)
+			}
+			if not, ok := strings.CutPrefix(tt.contains, "!"); ok {
+				if strings.Contains(rec.Body.String(), not) {
+					t.Errorf("%s body contains %s: %s", tt.path, not, rec.Body)
+				}
+			} else if !strings.Contains(rec.Body.String(), tt.contains) {
+				t.Errorf("%s body does not contain %s: %s", tt.path, tt.contains, rec.Body)
+			}
+			if rec.Code == http.StatusFound {
+				if got, want := rec.Header().Get("Location"), "https://spring"; got != want {
+					t.Errorf("location got %s want %s", got, want)
+				}
+				if got := ns.clicks.get("spring-sale"); got != 1 {
+					t.Errorf("got %d clicks want 1", got)
+				}
+			}
+		})
+	}
+
+	if _, err := newServer(false, "", "", 0, 0, withSuggestions(3, 1.5)); err == nil {
+		t.Error("expected error for confidence over 1")
+	}
+}
+
+// TestSuggestRedirectLimits checks that suggestions are redirected to
+// whatever the number of suggestions shown
+func TestSuggestRedirectLimits(t *testing.T) {
+	links := testLinks(map[string]string{
+		"spring-sale": "https://spring",
+		"abc1":        "https://abc1",
+		"abc2":        "https://abc2",
+	})
+	tests := []struct {
+		shown    int
+		path     string
+		status   int
+		contains string
+	}{
+		{1, "/abc", 404, `<a href="/abc1">`}, // ties are not redirected
+		{1, "/abc", 404, `!<a href="/abc2">`},
+		{0, "/abc", 404, "!Did you mean"},
+		{0, "/sprnig-sale", 302, ""},
+		{1, "/sprnig-sale", 302, ""},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
+			ns, err := newServer(false, "", "", 0, 0, withSuggestions(tt.shown, 0.5))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ns.store = newMemStore(links)
+			rec := httptest.NewRecorder()
+			ns.mux().ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+			if rec.Code != tt.status {
+				t.Errorf("%s status got %d want %d", tt.path, rec.Code, tt.status)
+			}
+			if not, ok := strings.CutPrefix(tt.contains, "!"); ok {
+				if strings.Contains(rec.Body.String(), not) {
+					t.Errorf("%s body contains %s: %s", tt.path, not, rec.Body)
+				}
+			} else if !strings.Contains(rec.Body.String(), tt.contains) {
+				t.Errorf("%s body does not contain %s: %s", tt.path, tt.contains, rec.Body)
+			}
+		})
+	}
+}
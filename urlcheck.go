@@ -1,14 +1,22 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 )
 
-// urlcheck checks if remote urls return 200 status
+// urlcheck checks if remote urls return 200 status and serve an
+// expected content type, by default html
 
 const httpWorkers = 12 // also goroutines to spin up
 const httpTimeout time.Duration = 200 * time.Millisecond
@@ -19,8 +27,13 @@ var NonHTMLPageType error = errors.New("non html page type")
 // that client, which are parameterised to allow for convenient swapping
 // out during testing
 type getClient struct {
-	client  *http.Client
-	workers int
+	client       *http.Client
+	workers      int
+	contentTypes map[string][]string // allowed content types by target
+	sniff        bool                // inspect the start of html bodies
+	maxHops      int                 // flag longer redirect chains, if set
+	retry        retryPolicy         // retries of transient failures
+	hosts        *hostLimiter        // per-host request limits, if set
 }
 
 // NewGetClient initialises a new getClient.
@@ -43,28 +56,127 @@ func NewGetClient(workers int, timeout time.Duration) *getClient {
 	return &g
 }
 
-// Check checks a set of urls, returning the count of processed errors
-// until an error or completion
-func (g *getClient) Check(urls []string) (count int, errorCount int) {
+// allowContentTypes records the content types allowed for the target
+// of each link from the link's metadata. Targets are checked once, so
+// the types allowed for a target shared by several links are merged,
+// including the default types for links which set none; a target is
+// accepted if any of its links would accept it. It must be called
+// before checking starts.
+func (g *getClient) allowContentTypes(links []Link) {
+	if g.contentTypes == nil {
+		g.contentTypes = map[string][]string{}
+	}
+	for _, l := range links {
+		types := linkContentTypes(l)
+		if types == nil {
+			types = defaultContentTypes
+		}
+		for _, t := range types {
+			t = strings.ToLower(t)
+			if !slices.Contains(g.contentTypes[l.Target], t) {
+				g.contentTypes[l.Target] = append(g.contentTypes[l.Target], t)
+			}
+		}
+	}
+}
+
+// hop is a redirect response in a redirect chain
+type hop struct {
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+}
+
+// checkResult is the result of checking a url. Redirects records each
+// redirect followed, in order, and FinalURL is the url reached after
+// any redirects. Attempts is the number of times the url was fetched,
+// and the other fields are from the last attempt.
+type checkResult struct {
+	URL        string
+	Status     int
+	Duration   time.Duration
+	Redirects  []hop
+	FinalURL   string
+	Attempts   int
+	Err        error
+	retryAfter time.Duration // wait asked for by a 429 or 503 response
+}
+
+// OK reports if the check succeeded with a 200 status
+func (r checkResult) OK() bool {
+	return r.Err == nil && r.Status == http.StatusOK
+}
 
-	type result struct {
-		url    string
-		status int
-		err    error
+// ErrType classifies the reason for a failed check, returning an empty
+// string if the check succeeded
+func (r checkResult) ErrType() string {
+	if r.Err == nil {
+		if r.Status != http.StatusOK {
+			return "status"
+		}
+		return ""
 	}
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var opErr *net.OpError
+	var certErr *tls.CertificateVerificationError
+	var urlErr *url.Error
+	switch {
+	case errors.As(r.Err, &dnsErr):
+		return "dns"
+	case errors.As(r.Err, &certErr):
+		return "tls"
+	case errors.As(r.Err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(r.Err, &opErr):
+		return "connection"
+	case errors.As(r.Err, &urlErr) && strings.Contains(urlErr.Err.Error(), "unsupported protocol scheme"):
+		return "invalid-url"
+	case errors.Is(r.Err, CrossDomainRedirect):
+		return "cross-domain"
+	case errors.Is(r.Err, TooManyRedirects):
+		return "too-many-hops"
+	case errors.Is(r.Err, RootRedirect):
+		return "root-redirect"
+	case errors.Is(r.Err, NonHTMLPageType):
+		return "content-type"
+	case errors.Is(r.Err, ParkedDomainPage):
+		return "parked"
+	case errors.Is(r.Err, LoginWallPage):
+		return "login-wall"
+	}
+	return "other"
+}
 
-	getURL := func(urlChan <-chan string, results chan<- result) {
+// Stream checks a set of urls using the client's workers, sending a
+// result for each url on the returned channel in the order in which
+// the checks complete. The channel is closed when all urls have been
+// checked or, if the context is cancelled, when the workers have
+// stopped. Checks interrupted by cancellation are not reported.
+func (g *getClient) Stream(ctx context.Context, urls []string) <-chan checkResult {
+
+	getURL := func(urlChan <-chan string, results chan<- checkResult) {
 		for u := range urlChan {
-			status, err := g.get(u)
-			results <- result{u, status, err}
+			if ctx.Err() != nil {
+				return
+			}
+			r := g.retryFetch(ctx, u)
+			if ctx.Err() != nil && errors.Is(r.Err, ctx.Err()) {
+				return
+			}
+			results <- r
 		}
 	}
 
 	urlChan := make(chan string, len(urls))
-	resultChan := make(chan result)
+	resultChan := make(chan checkResult, len(urls)) // workers never block
 
+	var wg sync.WaitGroup
 	for range g.workers {
-		go getURL(urlChan, resultChan)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			getURL(urlChan, resultChan)
+		}()
 	}
 
 	for _, uu := range urls {
@@ -72,35 +184,76 @@ func (g *getClient) Check(urls []string) (count int, errorCount int) {
 	}
 	close(urlChan)
 
-	for rr := range resultChan {
-		count++
-		if rr.err != nil {
-			errorCount++
-			fmt.Printf("%s\n   %v\n", rr.url, rr.err)
-		}
-		if rr.err == nil && rr.status != 200 {
-			errorCount++
-			fmt.Printf("%s\n   status %d\n", rr.url, rr.status)
-		}
-		if count == len(urls) {
-			break
-		}
+	go func() {
+		wg.Wait()
+		close(resultChan)
+	}()
+	return resultChan
+}
+
+// Check checks a set of urls, returning a result for each url in the
+// order in which the checks complete. If the context is cancelled
+// before every check completes the results completed so far are
+// returned with the context's error.
+func (g *getClient) Check(ctx context.Context, urls []string) ([]checkResult, error) {
+	results := make([]checkResult, 0, len(urls))
+	for r := range g.Stream(ctx, urls) {
+		results = append(results, r)
 	}
-	return count, errorCount
+	if len(results) < len(urls) {
+		return results, ctx.Err()
+	}
+	return results, nil
 }
 
 // get gets a URL, reporting the status and erroring if the page is not
 // of an html type.
 func (g *getClient) get(url string) (status int, err error) {
-	resp, err := g.client.Get(url)
+	r := g.fetch(context.Background(), url)
+	return r.Status, r.Err
+}
+
+// fetch gets a URL, recording the status, time taken and final url. The
+// request is cancelled if the context is cancelled.
+func (g *getClient) fetch(ctx context.Context, u string) checkResult {
+	r := checkResult{URL: u}
+	start := time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	resp, err := g.client.Do(req)
+	r.Err = err
 	if resp != nil {
-		status = resp.StatusCode
+		r.Status = resp.StatusCode
+		r.FinalURL = resp.Request.URL.String()
+		r.Redirects = redirects(resp)
+		if r.Status == http.StatusTooManyRequests || r.Status == http.StatusServiceUnavailable {
+			r.retryAfter, _ = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		}
+		if r.Status == http.StatusOK {
+			r.Err = inspect(resp, g.contentTypes[u], g.sniff)
+		}
+		r.Err = errors.Join(traceRedirects(r, g.maxHops), r.Err)
 		_, err = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
 		if err != nil {
-			return 0, fmt.Errorf("body discard error: %w", err)
+			r.Status = 0
+			r.Err = fmt.Errorf("body discard error: %w", err)
 		}
-		resp.Body.Close()
 	}
-	return status, err
+	r.Duration = time.Since(start)
+	return r
+}
 
+// redirects returns the redirect chain that led to resp, by following
+// each request back to the redirect response that caused it
+func redirects(resp *http.Response) []hop {
+	hops := []hop{}
+	for req := resp.Request; req.Response != nil; req = req.Response.Request {
+		hops = append([]hop{{req.Response.Request.URL.String(), req.Response.StatusCode}}, hops...)
+	}
+	return hops
 }
@@ -1,9 +1,15 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -19,8 +25,8 @@ func TestGet(t *testing.T) {
 	}{
 		{"https://www.google.com", 200, false},
 		{"www.google.com", 0, true},
-		// {"https://jsonplaceholder.typicode.com/todos/1", 200, true}, // non-html error
-		{"https://www.theguardian.com/404", 404, false}, // 404
+		{"https://jsonplaceholder.typicode.com/todos/1", 200, true}, // non-html error
+		{"https://www.theguardian.com/404", 404, false},             // 404
 
 	}
 
@@ -41,6 +47,17 @@ func TestGet(t *testing.T) {
 	}
 }
 
+// countErrors counts the failed results
+func countErrors(results []checkResult) int {
+	n := 0
+	for _, r := range results {
+		if !r.OK() {
+			n++
+		}
+	}
+	return n
+}
+
 func TestGetURLs(t *testing.T) {
 
 	tests := []struct {
@@ -87,7 +104,11 @@ func TestGetURLs(t *testing.T) {
 	for i, tt := range tests {
 		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
 			g := NewGetClient(tt.workers, tt.timeout)
-			count, errCount := g.Check(tt.urls)
+			results, err := g.Check(context.Background(), tt.urls)
+			if err != nil {
+				t.Fatal(err)
+			}
+			count, errCount := len(results), countErrors(results)
 			if errCount != tt.errCount {
 				t.Errorf("error count got %d expected %d", errCount, tt.errCount)
 			}
@@ -104,11 +125,11 @@ func TestWithTimeout(t *testing.T) {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprintln(w, string("ok"))
+		fmt.Fprintln(w, string("<html>ok</html>"))
 	})
 	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
 		time.Sleep(time.Millisecond * 100)
-		fmt.Fprintln(w, string("slow"))
+		fmt.Fprintln(w, string("<html>slow</html>"))
 	})
 	mux.HandleFunc("/404", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
@@ -144,7 +165,11 @@ func TestWithTimeout(t *testing.T) {
 	for i, tt := range tests {
 		t.Run(fmt.Sprintf("test_%d", i), func(t *testing.T) {
 			g := NewGetClient(tt.workers, tt.timeout)
-			count, errCount := g.Check(tt.urls)
+			results, err := g.Check(context.Background(), tt.urls)
+			if err != nil {
+				t.Fatal(err)
+			}
+			count, errCount := len(results), countErrors(results)
 			if errCount != tt.errCount {
 				t.Errorf("error count got %d expected %d", errCount, tt.errCount)
 			}
@@ -154,3 +179,170 @@ func TestWithTimeout(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckResultErrType(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer slow.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	g := NewGetClient(1, 50*time.Millisecond)
+	tests := []struct {
+		result  checkResult
+		errType string
+	}{
+		{checkResult{Status: 200}, ""},
+		{checkResult{Status: 500}, "status"},
+		{g.fetch(context.Background(), slow.URL), "timeout"},
+		{g.fetch(context.Background(), closed.URL), "connection"},
+		{g.fetch(context.Background(), "www.example.com"), "invalid-url"},
+		{checkResult{Err: NonHTMLPageType}, "content-type"},
+		{checkResult{Err: errors.New("x")}, "other"},
+	}
+	for i, tt := range tests {
+		if got, want := tt.result.ErrType(), tt.errType; got != want {
+			t.Errorf("test %d error type got %q want %q (%v)", i, got, want, tt.result.Err)
+		}
+	}
+}
+
+// TestCheckResults checks the detail of the structured results,
+// including redirect chains, and the text reporter
+func TestCheckResults(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "<html>ok</html>")
+	})
+	mux.HandleFunc("/a", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/b", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/b", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/c", http.StatusFound)
+	})
+	mux.HandleFunc("/404", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	g := NewGetClient(2, 500*time.Millisecond)
+	results := map[string]checkResult{}
+	for r := range g.Stream(context.Background(), []string{ts.URL + "/", ts.URL + "/a", ts.URL + "/404"}) {
+		results[r.URL] = r
+	}
+	if got, want := len(results), 3; got != want {
+		t.Fatalf("got %d results want %d", got, want)
+	}
+
+	ok := results[ts.URL+"/"]
+	if !ok.OK() || ok.Duration <= 0 || len(ok.Redirects) != 0 {
+		t.Errorf("unexpected ok result %+v", ok)
+	}
+
+	redirected := results[ts.URL+"/a"]
+	wantHops := []hop{{ts.URL + "/a", 301}, {ts.URL + "/b", 302}}
+	if !reflect.DeepEqual(redirected.Redirects, wantHops) {
+		t.Errorf("redirects got %v want %v", redirected.Redirects, wantHops)
+	}
+	if got, want := redirected.FinalURL, ts.URL+"/c"; got != want {
+		t.Errorf("final url got %s want %s", got, want)
+	}
+
+	missing := results[ts.URL+"/404"]
+	if missing.OK() || missing.Status != 404 || missing.Err != nil {
+		t.Errorf("unexpected missing result %+v", missing)
+	}
+
+	var buf bytes.Buffer
+	errCount, err := writeText(&buf, []checkResult{ok, redirected, missing})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errCount != 1 {
+		t.Errorf("text reporter got %d errors want 1", errCount)
+	}
+	want := ts.URL + "/404\n   status 404\nurl check reported 1 errors in 3 url checks\n"
+	if got := buf.String(); got != want {
+		t.Errorf("text report got %q want %q", got, want)
+	}
+}
+
+// TestCheckCancel checks that cancelling a check stops in-flight
+// requests and returns the results completed so far
+func TestCheckCancel(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "<html>ok</html>")
+	})
+	mux.HandleFunc("/hang", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done() // until the client goes away
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	urls := []string{ts.URL + "/", ts.URL + "/?2"}
+	for i := range 10 {
+		urls = append(urls, fmt.Sprintf("%s/hang?%d", ts.URL, i))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	g := NewGetClient(3, 10*time.Second)
+	start := time.Now()
+	results, err := g.Check(ctx, urls)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("check took %v after cancellation", elapsed)
+	}
+	if got, want := len(results), 2; got != want {
+		t.Fatalf("got %d partial results want %d: %+v", got, want, results)
+	}
+	for _, r := range results {
+		if !r.OK() {
+			t.Errorf("unexpected partial result %+v", r)
+		}
+	}
+
+	// an already cancelled context checks nothing
+	results, err = g.Check(ctx, urls)
+	if err == nil || len(results) != 0 {
+		t.Errorf("expected no results and an error, got %d results and %v", len(results), err)
+	}
+}
+
+// cancelTransport cancels a context when it responds to a request
+type cancelTransport struct {
+	cancel context.CancelFunc
+}
+
+func (c cancelTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.cancel()
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"text/html"}},
+		Body:       io.NopCloser(strings.NewReader("<html>ok</html>")),
+		Request:    r,
+	}, nil
+}
+
+// TestCheckCancelAfterResults checks that a check which completes every
+// url is not reported as cancelled if the context is cancelled later
+func TestCheckCancelAfterResults(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	g := NewGetClient(1, time.Second)
+	g.client.Transport = cancelTransport{cancel}
+	results, err := g.Check(ctx, []string{"https://example.com/"})
+	if err != nil || len(results) != 1 || !results[0].OK() {
+		t.Errorf("got %+v %v, want one ok result and no error", results, err)
+	}
+	if ctx.Err() == nil {
+		t.Error("expected the context to be cancelled")
+	}
+}
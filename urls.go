@@ -2,35 +2,303 @@ package main
 
 import (
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
 var shortURLValidRegex *regexp.Regexp = regexp.MustCompile("^[-A-Za-z0-9]+$")
 
-// urls makes a map of short urls "su" to redirection urls "ru" from a
-// csv file in su,ru format.
+// redirectStatuses are the http status codes that may be used for
+// redirecting a short url
+var redirectStatuses = []int{
+	http.StatusMovedPermanently,  // 301
+	http.StatusFound,             // 302
+	http.StatusTemporaryRedirect, // 307
+	http.StatusPermanentRedirect, // 308
+}
+
+// validStatus reports if status is a permitted redirect status
+func validStatus(status int) bool {
+	for _, s := range redirectStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// parseStatus parses a redirect status code. An empty string returns 0,
+// meaning the server default should be used.
+func parseStatus(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	status, err := strconv.Atoi(s)
+	if err != nil || !validStatus(status) {
+		return 0, fmt.Errorf("redirect status %q is not one of 301, 302, 307 or 308", s)
+	}
+	return status, nil
+}
+
+// column names recognised in an optional csv header row. Other named
+// columns are kept as per-link metadata.
+const (
+	colShort  = "short"
+	colTarget = "target"
+	colStatus = "status"
+)
+
+// columns records the positions of fields in a csv record. A negative
+// position means the column is not present.
+type columns struct {
+	short, target, status int
+	meta                  map[int]string // position to metadata name
+}
+
+// metaColumn returns the position of a metadata column
+func (c columns) metaColumn(name string) (int, bool) {
+	for i, n := range c.meta {
+		if n == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// defaultColumns are the su,ru[,status] columns of a csv file without a
+// header row
+var defaultColumns = columns{short: 0, target: 1, status: 2}
+
+// csvError is a link file validation error, recording the line and
+// column of the field at fault
+type csvError struct {
+	Line   int
+	Column int
+	Err    error
+}
+
+// Error reports the line, column, if known, and error
+func (e *csvError) Error() string {
+	if e.Column == 0 {
+		return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+	}
+	return fmt.Sprintf("line %d, column %d: %v", e.Line, e.Column, e.Err)
+}
+
+// Unwrap returns the underlying error
+func (e *csvError) Unwrap() error {
+	return e.Err
+}
+
+// csvErrors are the errors found when validating a link file, in line
+// order
+type csvErrors []*csvError
+
+// Error reports each error on its own line
+func (e csvErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, ce := range e {
+		msgs[i] = ce.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap returns the individual errors
+func (e csvErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, ce := range e {
+		errs[i] = ce
+	}
+	return errs
+}
+
+// parseHeader reports if record is a header row, being a row naming
+// both the short and target columns, and returns the columns it names.
+// Column names are trimmed and made lowercase. Errors are reported with
+// the position in record of the column at fault.
+func parseHeader(record []string) (columns, bool, int, error) {
+	cols := columns{short: -1, target: -1, status: -1, meta: map[int]string{}}
+	names := map[string]bool{}
+	dup := -1
+	for i, name := range record {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case colShort:
+			cols.short = i
+		case colTarget:
+			cols.target = i
+		case colStatus:
+			cols.status = i
+		default:
+			cols.meta[i] = name
+		}
+		if name != "" && names[name] && dup < 0 {
+			dup = i
+		}
+		names[name] = true
+	}
+	if cols.short < 0 || cols.target < 0 {
+		return cols, false, 0, nil
+	}
+	if dup >= 0 {
+		name := strings.ToLower(strings.TrimSpace(record[dup]))
+		return cols, true, dup, fmt.Errorf("csv header has duplicate column %q", name)
+	}
+	for i := range record {
+		if name, ok := cols.meta[i]; ok && name == "" {
+			return cols, true, i, fmt.Errorf("csv header column %d has no name", i+1)
+		}
+	}
+	return cols, true, 0, nil
+}
+
+// field returns the field at position i of record, or an empty string
+// if the column is not present
+func field(record []string, i int) string {
+	if i < 0 || i >= len(record) {
+		return ""
+	}
+	return record[i]
+}
+
+// cleanShort applies the su operations and checks described for urls,
+// other than the check for duplicates
+func cleanShort(su string) (string, error) {
+	// su operations
+	su = strings.TrimSpace(su)
+	su = strings.TrimRight(su, "/")
+
+	// su checks
+	if strings.Contains(su, " ") {
+		return su, fmt.Errorf("short url %s has a space", su)
+	}
+	if !shortURLValidRegex.MatchString(su) {
+		return su, fmt.Errorf("short url %s has invalid characters", su)
+	}
+	if err := checkReserved(su); err != nil {
+		return su, err
+	}
+	return su, nil
+}
+
+// cleanTarget applies the ru operations and checks described for urls
+func cleanTarget(ru string) (string, error) {
+	// ru operations
+	ru = strings.TrimSpace(ru)
+
+	// ru checks
+	if strings.Index(ru, "http") != 0 {
+		return ru, fmt.Errorf("target %s does not start with http", ru)
+	}
+	return ru, nil
+}
+
+// validateLink applies the operations and checks described for urls to
+// a single link, other than the check for duplicates, returning the
+// cleaned link
+func validateLink(l Link) (Link, error) {
+	var err error
+	l.Short, err = cleanShort(l.Short)
+	if err != nil {
+		return l, err
+	}
+	l.Target, err = cleanTarget(l.Target)
+	if err != nil {
+		return l, err
+	}
+	if l.Status != 0 && !validStatus(l.Status) {
+		return l, fmt.Errorf("short url %s redirect status %d is not one of 301, 302, 307 or 308", l.Short, l.Status)
+	}
+	for k := range l.Meta {
+		switch k {
+		case "", colShort, colTarget, colStatus:
+			return l, fmt.Errorf("short url %s has invalid metadata name %q", l.Short, k)
+		}
+		if k != strings.ToLower(strings.TrimSpace(k)) {
+			return l, fmt.Errorf("short url %s metadata name %q is not trimmed lowercase", l.Short, k)
+		}
+	}
+	if err := checkFallback(l.Short, l.Meta[metaFallback]); err != nil {
+		return l, err
+	}
+	if err := checkPassthrough(l.Short, l.Meta); err != nil {
+		return l, err
+	}
+	return l, nil
+}
+
+// urls makes a map of short urls "su" to links from a csv file in
+// su,ru[,status] format, where ru is the redirection url and the
+// optional status is the http redirect status code. urls stops at the
+// first invalid record, returning a *csvError; see validateURLs to
+// report all errors.
+//
+// The file may instead start with a header row naming its columns, which
+// must include "short" and "target" and may include "status". Any other
+// named columns, such as owner, tags or notes, are kept as link
+// metadata. Empty metadata values are ignored.
 //
 // su operations:
 // * trimmed of spaces
 // * trailing "/" character removed
-// (it might be sensible in future to force to lowercase)
+// (short urls are kept as written; see normalise for case insensitive
+// matching)
 //
 // su checks:
 // * no duplicate su values
 // * no spaces
 // * only letters, numbers and "-" character
+// * not the name of one of the server's routes, such as "static"
 //
 // ru operations:
 // * trimmed of spaces
 //
 // ru checks:
 // * starts with http
-func urls(r io.Reader) (map[string]string, error) {
-	m := map[string]string{}
+//
+// status checks:
+// * empty, or one of 301, 302, 307 or 308
+func urls(r io.Reader) (map[string]Link, error) {
+	return parseURLs(r, false)
+}
+
+// validateURLs is like urls but continues past invalid records,
+// returning every problem found as csvErrors. The returned map contains
+// the valid links.
+func validateURLs(r io.Reader) (map[string]Link, error) {
+	return parseURLs(r, true)
+}
+
+// parseURLs parses a csv link file, either stopping at the first error
+// or, if all is set, collecting every error.
+func parseURLs(r io.Reader, all bool) (map[string]Link, error) {
+	m := map[string]Link{}
+	lines := map[string]int{} // line of each su, for reporting duplicates
+	var errs csvErrors
+
 	c := csv.NewReader(r)
+	c.FieldsPerRecord = -1 // the status field is optional
+	cols := defaultColumns
+	first := true
+
+	// report records an error at the position of field i of the current
+	// record, returning true if parsing should stop
+	report := func(i int, err error) bool {
+		line, column := c.FieldPos(i)
+		errs = append(errs, &csvError{Line: line, Column: column, Err: err})
+		return !all
+	}
+
+records:
 	for {
 		var su, ru string
 		record, err := c.Read()
@@ -38,37 +306,220 @@ func urls(r io.Reader) (map[string]string, error) {
 			break
 		}
 		if err != nil {
-			return m, fmt.Errorf("csv reading error: %v", err)
+			var pe *csv.ParseError
+			if !errors.As(err, &pe) {
+				errs = append(errs, &csvError{Err: fmt.Errorf("csv reading error: %v", err)})
+				break
+			}
+			errs = append(errs, &csvError{Line: pe.Line, Column: pe.Column, Err: fmt.Errorf("csv reading error: %v", pe.Err)})
+			if !all {
+				break
+			}
+			continue
+		}
+
+		if first {
+			first = false
+			hCols, isHeader, i, err := parseHeader(record)
+			if err != nil {
+				report(i, err)
+				break // the remaining records cannot be interpreted
+			}
+			if isHeader {
+				cols = hCols
+				c.FieldsPerRecord = len(record)
+				continue
+			}
 		}
-		if len(record) != 2 {
-			return m, fmt.Errorf("csv record does not have 2 fields: %v", record)
+		// files without a header (and so without metadata) have 2 or 3 fields
+		if cols.meta == nil && len(record) != 2 && len(record) != 3 {
+			if report(0, fmt.Errorf("csv record does not have 2 or 3 fields: %v", record)) {
+				break
+			}
+			continue
 		}
 
-		su, ru = record[0], record[1]
+		valid := true
 
-		// su operations
-		su = strings.TrimSpace(su)
-		su = strings.TrimRight(su, "/")
+		su, err = cleanShort(field(record, cols.short))
+		if err == nil {
+			if line, exists := lines[su]; exists {
+				err = fmt.Errorf("short url %s already exists at line %d", su, line)
+			}
+		}
+		if err != nil {
+			valid = false
+			if report(cols.short, err) {
+				break
+			}
+		}
 
-		// su checks
-		if _, exists := m[su]; exists {
-			return m, fmt.Errorf("short url %s already exists: %v", su, record)
+		ru, err = cleanTarget(field(record, cols.target))
+		if err != nil {
+			valid = false
+			if report(cols.target, err) {
+				break
+			}
 		}
-		if strings.Contains(su, " ") {
-			return m, fmt.Errorf("short url %s has a space: %v", su, record)
+
+		// status checks
+		status, err := parseStatus(field(record, cols.status))
+		if err != nil {
+			valid = false
+			if report(cols.status, fmt.Errorf("short url %s %v", su, err)) {
+				break
+			}
 		}
-		if !shortURLValidRegex.MatchString(su) {
-			return m, fmt.Errorf("short url %s has invalid characters: %v", su, record)
+
+		// fallback checks
+		if i, ok := cols.metaColumn(metaFallback); ok {
+			if err := checkFallback(su, field(record, i)); err != nil {
+				valid = false
+				if report(i, err) {
+					break
+				}
+			}
 		}
 
-		// ru operations
-		ru = strings.TrimSpace(ru)
+		// passthrough checks
+		for _, name := range []string{metaQuery, metaPath} {
+			if i, ok := cols.metaColumn(name); ok {
+				if err := checkPassthrough(su, map[string]string{name: field(record, i)}); err != nil {
+					valid = false
+					if report(i, err) {
+						break records
+					}
+				}
+			}
+		}
 
-		// ru checks
-		if strings.Index(ru, "http") != 0 {
-			return m, fmt.Errorf("target %s does not start with http: %v", ru, record)
+		line, _ := c.FieldPos(cols.short)
+		if _, exists := lines[su]; !exists {
+			lines[su] = line
+		}
+		if !valid {
+			continue
 		}
-		m[su] = ru
+
+		// metadata
+		var meta map[string]string
+		for i, name := range cols.meta {
+			v := strings.TrimSpace(field(record, i))
+			if v == "" {
+				continue
+			}
+			if meta == nil {
+				meta = map[string]string{}
+			}
+			meta[name] = v
+		}
+		m[su] = Link{Short: su, Target: ru, Status: status, Meta: meta, line: line}
+	}
+
+	switch {
+	case len(errs) == 0:
+		return m, nil
+	case !all:
+		return m, errs[0]
+	default:
+		return m, errs
+	}
+}
+
+// sortedLinks returns the links in m in their original csv file order,
+// followed by any links without a line number sorted by short url
+func sortedLinks(m map[string]Link) []Link {
+	links := make([]Link, 0, len(m))
+	for _, l := range m {
+		links = append(links, l)
+	}
+	sort.Slice(links, func(i, j int) bool {
+		li, lj := links[i].line, links[j].line
+		switch {
+		case li != 0 && lj != 0 && li != lj:
+			return li < lj
+		case li != 0 && lj == 0:
+			return true
+		case li == 0 && lj != 0:
+			return false
+		}
+		return links[i].Short < links[j].Short
+	})
+	return links
+}
+
+// writeURLs writes links in the csv format read by urls. A header row is
+// only written if any link has metadata, in which case the metadata
+// columns follow the short, target and status columns in name order.
+// The status column is only written if needed.
+func writeURLs(w io.Writer, links []Link) error {
+	hasStatus := false
+	metaNames := map[string]bool{}
+	for _, l := range links {
+		if l.Status != 0 {
+			hasStatus = true
+		}
+		for k := range l.Meta {
+			metaNames[k] = true
+		}
+	}
+	names := make([]string, 0, len(metaNames))
+	for k := range metaNames {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	c := csv.NewWriter(w)
+	if len(names) > 0 {
+		hasStatus = true
+		err := c.Write(append([]string{colShort, colTarget, colStatus}, names...))
+		if err != nil {
+			return err
+		}
+	}
+	for _, l := range links {
+		record := []string{l.Short, l.Target}
+		if hasStatus {
+			status := ""
+			if l.Status != 0 {
+				status = strconv.Itoa(l.Status)
+			}
+			record = append(record, status)
+		}
+		for _, n := range names {
+			record = append(record, l.Meta[n])
+		}
+		if err := c.Write(record); err != nil {
+			return err
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
+
+// writeURLFile writes links to the csv file at path, replacing it
+// atomically by writing to a temporary file in the same directory
+func writeURLFile(path string, links []Link) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file: %v", err)
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+
+	err = writeURLs(tmp, links)
+	if err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write links: %v", err)
+	}
+	err = tmp.Close()
+	if err != nil {
+		return fmt.Errorf("could not close temporary file: %v", err)
+	}
+	// preserve the permissions of an existing file
+	if stat, err := os.Stat(path); err == nil {
+		_ = os.Chmod(tmp.Name(), stat.Mode().Perm())
+	} else {
+		_ = os.Chmod(tmp.Name(), 0644)
 	}
-	return m, nil
+	return os.Rename(tmp.Name(), path)
 }
@@ -1,8 +1,10 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -44,6 +46,16 @@ func TestURLs(t *testing.T) {
 			isErr: true, // su has space
 			count: 0,
 		},
+		{
+			input: "abc,http://def\nstatic,http://static",
+			isErr: true, // su is a route name
+			count: 0,
+		},
+		{
+			input: "Admin/,http://admin",
+			isErr: true, // su is a route name, ignoring case
+			count: 0,
+		},
 		{
 			input: "abc, def",
 			isErr: true, // ru does not have http
@@ -60,6 +72,31 @@ func TestURLs(t *testing.T) {
 			isErr: false,
 			count: 2,
 		},
+		{
+			input: "abc,https://def,302\nghi,https://xyz\njkl,https://jkl,",
+			isErr: false, // optional status
+			count: 3,
+		},
+		{
+			input: "abc,https://def, 308",
+			isErr: false, // status trimmed
+			count: 1,
+		},
+		{
+			input: "abc,https://def,200",
+			isErr: true, // not a redirect status
+			count: 0,
+		},
+		{
+			input: "abc,https://def,temporary",
+			isErr: true, // not a number
+			count: 0,
+		},
+		{
+			input: "abc,https://def,302,extra",
+			isErr: true, // too many fields
+			count: 0,
+		},
 	}
 
 	for i, tt := range tests {
@@ -82,6 +119,175 @@ func TestURLs(t *testing.T) {
 	}
 }
 
+func TestURLsStatus(t *testing.T) {
+	m, err := urls(strings.NewReader("abc,https://abc,307\ndef,https://def"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := m["abc"].Status, 307; got != want {
+		t.Errorf("abc status got %d want %d", got, want)
+	}
+	if got, want := m["def"].Status, 0; got != want {
+		t.Errorf("def status got %d want %d", got, want)
+	}
+
+	_, err = urls(strings.NewReader("abc,https://abc,304"))
+	if err == nil || !strings.Contains(err.Error(), "not one of 301, 302, 307 or 308") {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestURLsHeader(t *testing.T) {
+
+	tests := []struct {
+		input string
+		isErr bool
+		count int
+	}{
+		{
+			input: "short,target\nabc,https://def",
+			isErr: false,
+			count: 1,
+		},
+		{
+			input: " Target , SHORT ,status\nhttps://def,abc,302",
+			isErr: false, // columns in any order, names trimmed and folded
+			count: 1,
+		},
+		{
+			input: "short,target,owner,notes\nabc,https://def,rory,\nghi,https://xyz,,a note",
+			isErr: false,
+			count: 2,
+		},
+		{
+			input: "short,target,owner\nabc,https://def",
+			isErr: true, // record has fewer fields than the header
+			count: 0,
+		},
+		{
+			input: "short,target,owner,owner\nabc,https://def,a,b",
+			isErr: true, // duplicate column
+			count: 0,
+		},
+		{
+			input: "301,https://def,301\nghi,https://xyz",
+			isErr: false, // repeated fields in a data row are not duplicate columns
+			count: 2,
+		},
+		{
+			input: "short,target,,notes\nabc,https://def,a,b",
+			isErr: true, // unnamed column
+			count: 0,
+		},
+		{
+			input: "short,target\nabc,def",
+			isErr: true, // ru does not have http
+			count: 0,
+		},
+		{
+			input: "short,url\nabc,https://def",
+			isErr: true, // not a header, so ru does not have http
+			count: 0,
+		},
+		{
+			input: "short,target,fallback\nabc,https://abc,https://archive/abc\ndef,https://def,",
+			isErr: false,
+			count: 2,
+		},
+		{
+			input: "short,target,fallback\nabc,https://abc,archive/abc",
+			isErr: true, // fallback does not have http
+			count: 0,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("subtest_%d", i), func(t *testing.T) {
+			t.Parallel()
+			m, err := urls(strings.NewReader(tt.input))
+			if err == nil && tt.isErr {
+				t.Errorf("expected err for %v", m)
+			}
+			if err != nil && !tt.isErr {
+				t.Errorf("unexpected err %v", err)
+			}
+			if err == nil && len(m) != tt.count {
+				t.Errorf("m len %d expected %d", len(m), tt.count)
+			}
+		})
+	}
+}
+
+func TestURLsMetadata(t *testing.T) {
+	input := "short,target,status,owner,tags\nabc,https://abc,307,rory,x y\ndef,https://def,,,"
+	m, err := urls(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	abc := m["abc"]
+	if got, want := abc.Status, 307; got != want {
+		t.Errorf("abc status got %d want %d", got, want)
+	}
+	if got, want := abc.Meta, map[string]string{"owner": "rory", "tags": "x y"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("abc meta got %v want %v", got, want)
+	}
+	if got := m["def"].Meta; got != nil {
+		t.Errorf("def meta should be nil, got %v", got)
+	}
+}
+
+func TestURLsErrorPosition(t *testing.T) {
+	_, err := urls(strings.NewReader("abc,https://abc\ndef,https://def\nghi,ftp://ghi"))
+	var ce *csvError
+	if !errors.As(err, &ce) {
+		t.Fatalf("expected csvError, got %v", err)
+	}
+	if got, want := [2]int{ce.Line, ce.Column}, [2]int{3, 5}; got != want {
+		t.Errorf("position got %v want %v", got, want)
+	}
+	if got, want := ce.Error(), "line 3, column 5: target ftp://ghi does not start with http"; got != want {
+		t.Errorf("error got %q want %q", got, want)
+	}
+}
+
+func TestValidateURLs(t *testing.T) {
+	input := strings.Join([]string{
+		"short,target,status,owner",
+		"abc,https://abc,,rory",     // 2 ok
+		"a bc,https://abc,,",        // 3 space
+		"def,def,,",                 // 4 no http
+		"abc,https://abc,200,",      // 5 duplicate and status
+		"ghi,https://ghi,302,sarah", // 6 ok
+		"jkl,https://jkl",           // 7 field count
+		`mno,"https://mno,,`,        // 8 quote
+	}, "\n")
+
+	m, err := validateURLs(strings.NewReader(input))
+	var errs csvErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("expected csvErrors, got %v", err)
+	}
+	if got, want := len(m), 2; got != want {
+		t.Errorf("got %d valid links want %d", got, want)
+	}
+
+	want := [][2]int{{3, 1}, {4, 5}, {5, 1}, {5, 17}, {7, 1}, {8, 19}}
+	if len(errs) != len(want) {
+		t.Fatalf("got %d errors want %d:\n%v", len(errs), len(want), errs)
+	}
+	for i, e := range errs {
+		if got := [2]int{e.Line, e.Column}; got != want[i] {
+			t.Errorf("error %d position got %v want %v (%v)", i, got, want[i], e)
+		}
+	}
+	if !strings.Contains(errs[2].Error(), "already exists at line 2") {
+		t.Errorf("unexpected duplicate error %v", errs[2])
+	}
+	if got, want := strings.Count(errs.Error(), "\n"), len(errs)-1; got != want {
+		t.Errorf("expected one error per line, got %d newlines", got)
+	}
+}
+
 // TestURLData tests the stored csv file
 func TestURLData(t *testing.T) {
 	f, err := os.Open("data/short-urls.csv")
@@ -93,6 +299,6 @@ func TestURLData(t *testing.T) {
 		t.Fatal(err)
 	}
 	for k, v := range m {
-		t.Logf("%-40s : %s\n", k, v)
+		t.Logf("%-40s : %s\n", k, v.Target)
 	}
 }